@@ -0,0 +1,230 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api/helper"
+)
+
+type LogFormat int
+
+const (
+	// LogFormatSplit writes a "request" record before and a "response" record after the handler.
+	LogFormatSplit LogFormat = iota
+	// LogFormatAccess writes a single "access" record after the handler.
+	LogFormatAccess
+	// LogFormatCommon writes Apache Common Log Format lines to the output.
+	LogFormatCommon
+	// LogFormatCombined writes Apache Combined Log Format lines to the output.
+	LogFormatCombined
+)
+
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+type routeLevel struct {
+	pattern string
+	level   slog.Level
+}
+
+// AccessLog configures MiddlewareBase and the records it writes.
+// By default every request body is buffered and every request is logged at info level with the split format.
+type AccessLog struct {
+	format    LogFormat
+	output    io.Writer
+	routes    []routeLevel
+	exclude   []string
+	streaming []string
+	rate      float64
+	perSec    int
+	slow      time.Duration
+
+	mu     sync.Mutex
+	second int64
+	count  int
+}
+
+func newAccessLog(opts ...func(*AccessLog)) *AccessLog {
+	a := AccessLog{output: os.Stdout, rate: 1}
+	for _, o := range opts {
+		o(&a)
+	}
+	return &a
+}
+
+// WithLogFormat sets the record format, output is used by the Apache formats.
+func WithLogFormat(format LogFormat, output io.Writer) func(*AccessLog) {
+	return func(a *AccessLog) {
+		a.format = format
+		if output != nil {
+			a.output = output
+		}
+	}
+}
+
+// WithRouteLevel logs requests with a path matching the pattern at the level.
+// Patterns use path.Match syntax, the first matching pattern wins.
+// The Apache formats have no level, their lines are written when the logger enables it.
+func WithRouteLevel(pattern string, level slog.Level) func(*AccessLog) {
+	return func(a *AccessLog) {
+		a.routes = append(a.routes, routeLevel{pattern: pattern, level: level})
+	}
+}
+
+// WithExclude skips logging for requests with a path matching any of the patterns.
+func WithExclude(patterns ...string) func(*AccessLog) {
+	return func(a *AccessLog) {
+		a.exclude = append(a.exclude, patterns...)
+	}
+}
+
+// WithStreamingBody leaves the body of requests with a path matching any of the patterns unbuffered,
+// e.g. for large uploads.
+func WithStreamingBody(patterns ...string) func(*AccessLog) {
+	return func(a *AccessLog) {
+		a.streaming = append(a.streaming, patterns...)
+	}
+}
+
+// WithSampling keeps the given fraction of requests.
+// Errors and slow requests are always kept.
+func WithSampling(rate float64) func(*AccessLog) {
+	return func(a *AccessLog) {
+		a.rate = rate
+	}
+}
+
+// WithRateSampling keeps at most perSecond requests each second.
+// Errors and slow requests are always kept.
+func WithRateSampling(perSecond int) func(*AccessLog) {
+	return func(a *AccessLog) {
+		a.perSec = perSecond
+	}
+}
+
+// WithSlowThreshold marks requests taking at least d as slow.
+func WithSlowThreshold(d time.Duration) func(*AccessLog) {
+	return func(a *AccessLog) {
+		a.slow = d
+	}
+}
+
+func (a *AccessLog) sampling() bool {
+	return a.rate < 1 || a.perSec > 0
+}
+
+func (a *AccessLog) excluded(r *http.Request) bool {
+	return matchAny(a.exclude, r.URL.Path)
+}
+
+func (a *AccessLog) streamed(r *http.Request) bool {
+	return matchAny(a.streaming, r.URL.Path)
+}
+
+func matchAny(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *AccessLog) level(r *http.Request) slog.Level {
+	for _, route := range a.routes {
+		if ok, _ := path.Match(route.pattern, r.URL.Path); ok {
+			return route.level
+		}
+	}
+	return slog.LevelInfo
+}
+
+// keep always keeps server errors and slow requests,
+// other requests are kept by the configured sampling.
+func (a *AccessLog) keep(status int, duration time.Duration) bool {
+	if status >= http.StatusInternalServerError || (a.slow > 0 && duration >= a.slow) {
+		return true
+	}
+
+	if a.rate < 1 && rand.Float64() >= a.rate {
+		return false
+	}
+
+	if a.perSec <= 0 {
+		return true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now().Unix()
+	if now != a.second {
+		a.second, a.count = now, 0
+	}
+
+	a.count++
+	return a.count <= a.perSec
+}
+
+func (a *AccessLog) writeCommon(r *http.Request, i *intercept, start time.Time) {
+	user, _, _ := r.BasicAuth()
+
+	line := fmt.Sprintf(
+		"%s - %s [%s] %s %d %s",
+		helper.RemoteIp(r),
+		clfUser(user),
+		start.Format(clfTime),
+		strconv.Quote(r.Method+" "+r.URL.RequestURI()+" "+r.Proto),
+		i.StatusCode,
+		clfBytes(i.Bytes),
+	)
+
+	if a.format == LogFormatCombined {
+		line += fmt.Sprintf(" %s %s", clfQuote(r.Referer()), clfQuote(r.UserAgent()))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	io.WriteString(a.output, line+"\n")
+}
+
+func clfBytes(n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.Itoa(n)
+}
+
+// clfUser escapes the client supplied user name so it stays a single field,
+// as Apache does for %u.
+func clfUser(user string) string {
+	if user == "" {
+		return "-"
+	}
+
+	var b strings.Builder
+	for _, c := range []byte(user) {
+		if c <= ' ' || c >= 0x7f || c == '"' || c == '\\' {
+			fmt.Fprintf(&b, `\x%02x`, c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func clfQuote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
@@ -0,0 +1,170 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/bhmt/tittlemanscrest/api"
+	"github.com/bhmt/tittlemanscrest/logging"
+)
+
+func records(t *testing.T, out *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var got []map[string]any
+	for line := range strings.SplitSeq(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, record)
+	}
+	return got
+}
+
+func handlerStatus(status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	})
+}
+
+func TestAccessLogSplit(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+
+	api.MiddlewareBase(logger, handlerStatus(http.StatusOK)).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	got := records(t, &out)
+	if len(got) != 2 || got[0]["msg"] != "request" || got[1]["msg"] != "response" {
+		t.Errorf("split records mismatch, got=%v", got)
+	}
+}
+
+func TestAccessLogSingleRecord(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+
+	api.MiddlewareBase(logger, handlerStatus(http.StatusCreated), api.WithLogFormat(api.LogFormatAccess, nil)).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/items", nil))
+
+	got := records(t, &out)
+	if len(got) != 1 || got[0]["msg"] != "access" || got[0]["path"] != "/items" || got[0]["status"] != float64(http.StatusCreated) {
+		t.Errorf("access record mismatch, got=%v", got)
+	}
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil))
+
+	handler := api.MiddlewareBase(logger, http.HandlerFunc(handlerOk), api.WithLogFormat(api.LogFormatCombined, &out))
+
+	request := httptest.NewRequest(http.MethodGet, "/items?page=2", nil)
+	request.Header.Set("User-Agent", "curl/8.0")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	want := regexp.MustCompile(`^192\.0\.2\.1 - - \[.+\] "GET /items\?page=2 HTTP/1\.1" 200 2 "-" "curl/8\.0"\n$`)
+	if !want.MatchString(out.String()) {
+		t.Errorf("combined log mismatch, got=%q", out.String())
+	}
+
+	out.Reset()
+	request = httptest.NewRequest(http.MethodGet, "/items", nil)
+	request.Header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.9")
+	request.SetBasicAuth(`jon "daker" - [x]`, "secret")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	want = regexp.MustCompile(`^192\.0\.2\.1 - jon\\x20\\x22daker\\x22\\x20-\\x20\[x\] \[`)
+	if !want.MatchString(out.String()) {
+		t.Errorf("combined log should use the peer address and escape the user, got=%q", out.String())
+	}
+}
+
+func TestAccessLogCommonRouteLevel(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil))
+
+	mux := http.NewServeMux()
+	mux.Handle("/health", handlerStatus(http.StatusOK))
+	mux.Handle("/items", handlerStatus(http.StatusOK))
+	mux.Handle("/fail", handlerStatus(http.StatusInternalServerError))
+
+	handler := api.MiddlewareBase(
+		logger,
+		mux,
+		api.WithLogFormat(api.LogFormatCommon, &out),
+		api.WithRouteLevel("/health", slog.LevelDebug),
+		api.WithRouteLevel("/fail", slog.LevelDebug),
+	)
+
+	for _, path := range []string{"/health", "/items", "/fail"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "/items") || !strings.Contains(lines[1], "/fail") {
+		t.Errorf("common log want /items and /fail, got=%q", out.String())
+	}
+}
+
+func TestAccessLogRoutesAndSampling(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+
+	mux := http.NewServeMux()
+	mux.Handle("/health", handlerStatus(http.StatusOK))
+	mux.Handle("/metrics", handlerStatus(http.StatusOK))
+	mux.Handle("/items", handlerStatus(http.StatusOK))
+	mux.Handle("/fail", handlerStatus(http.StatusInternalServerError))
+
+	handler := api.MiddlewareBase(
+		logger,
+		mux,
+		api.WithLogFormat(api.LogFormatAccess, nil),
+		api.WithExclude("/metrics"),
+		api.WithRouteLevel("/health", slog.LevelDebug),
+		api.WithSampling(0),
+	)
+
+	for _, path := range []string{"/health", "/metrics", "/items", "/fail"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	got := records(t, &out)
+	if len(got) != 1 || got[0]["path"] != "/fail" {
+		t.Errorf("sampled records want only /fail, got=%v", got)
+	}
+}
+
+func TestAccessLogContextLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+
+	handler := api.MiddlewareBase(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).InfoContext(r.Context(), "handled")
+	}), api.WithLogFormat(api.LogFormatAccess, nil))
+
+	r := httptest.NewRequest(http.MethodGet, "/items", nil)
+	r.Header.Set("X-Request-Id", "req-1")
+	r.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	got := records(t, &out)
+	if len(got) != 2 || got[0]["msg"] != "handled" {
+		t.Fatalf("handler record missing got=%v", got)
+	}
+	if got[0]["request_id"] != "req-1" || got[0]["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("context logger should carry request_id and trace_id got=%v", got[0])
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/config"
+)
+
+// Admin serves diagnostics and runtime controls.
+// It exposes process internals and must only be served on a private port, e.g.
+//
+//	api.New(":6060", api.NewAdmin(level, cfg))
+type Admin struct {
+	mux    *http.ServeMux
+	level  *slog.LevelVar
+	config any
+}
+
+// NewAdmin creates the admin handler.
+// The level is the one shared by the loggers it controls, the log level endpoints
+// are left out when it is nil. Config is dumped with fields tagged `secret:"true"` redacted.
+func NewAdmin(level *slog.LevelVar, config any) *Admin {
+	a := Admin{mux: http.NewServeMux(), level: level, config: config}
+
+	a.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	a.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	a.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	a.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	a.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	a.mux.HandleFunc("GET /debug/goroutines", a.goroutines)
+	a.mux.HandleFunc("GET /debug/build", a.build)
+	a.mux.HandleFunc("GET /debug/runtime", a.runtime)
+	a.mux.HandleFunc("GET /debug/config", a.configuration)
+	if level != nil {
+		a.mux.HandleFunc("GET /debug/loglevel", a.getLevel)
+		a.mux.HandleFunc("PUT /debug/loglevel", a.setLevel)
+	}
+
+	return &a
+}
+
+// Handle registers additional admin endpoints.
+func (a *Admin) Handle(pattern string, handler http.Handler) {
+	a.mux.Handle(pattern, handler)
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *Admin) goroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+func (a *Admin) build(w http.ResponseWriter, r *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		http.Error(w, "build info not available", http.StatusNotFound)
+		return
+	}
+
+	settings := map[string]string{}
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+
+	writeJson(w, map[string]any{
+		"go_version": info.GoVersion,
+		"path":       info.Path,
+		"version":    info.Main.Version,
+		"settings":   settings,
+	})
+}
+
+func (a *Admin) runtime(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	writeJson(w, map[string]any{
+		"goroutines": runtime.NumGoroutine(),
+		"cpus":       runtime.NumCPU(),
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+		"memory": map[string]uint64{
+			"alloc":        m.Alloc,
+			"total_alloc":  m.TotalAlloc,
+			"sys":          m.Sys,
+			"heap_alloc":   m.HeapAlloc,
+			"heap_inuse":   m.HeapInuse,
+			"heap_idle":    m.HeapIdle,
+			"heap_objects": m.HeapObjects,
+			"stack_inuse":  m.StackInuse,
+		},
+		"gc": map[string]any{
+			"num_gc":       m.NumGC,
+			"pause_total":  time.Duration(m.PauseTotalNs).String(),
+			"last_gc":      time.Unix(0, int64(m.LastGC)).UTC(),
+			"next_gc":      m.NextGC,
+			"cpu_fraction": m.GCCPUFraction,
+		},
+	})
+}
+
+func (a *Admin) configuration(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, config.Redact(a.config))
+}
+
+func (a *Admin) getLevel(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, map[string]string{"level": a.level.Level().String()})
+}
+
+// setLevel accepts the level as a query parameter or plain text body, e.g. debug, info, warn, error.
+func (a *Admin) setLevel(w http.ResponseWriter, r *http.Request) {
+	value := r.URL.Query().Get("level")
+	if value == "" {
+		body, _ := io.ReadAll(http.MaxBytesReader(w, r.Body, 64))
+		value = strings.TrimSpace(string(body))
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.level.Set(level)
+	writeJson(w, map[string]string{"level": a.level.Level().String()})
+}
+
+func writeJson(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/cache"
+	"github.com/bhmt/tittlemanscrest/repository"
+)
+
+// CachedApiKey is an api key held by ApiKeyAuth with the time it was read from the session.
+// Missing marks a prefix the session has no key for.
+type CachedApiKey struct {
+	Key     repository.ApiKey
+	Loaded  time.Time
+	Missing bool
+}
+
+// ApiKeyAuth validates api keys against the session through an LRU.
+// Cached keys are read again after the refresh interval, which bounds how long
+// a key revoked by another process stays usable. Unknown prefixes are cached for
+// the miss interval, so repeated bad keys do not reach the session.
+type ApiKeyAuth struct {
+	session *repository.Session
+	keys    *cache.LRU[string, CachedApiKey]
+	refresh time.Duration
+	miss    time.Duration
+
+	mu   sync.Mutex
+	used map[int64]time.Time
+}
+
+func NewApiKeyAuth(session *repository.Session, keys *cache.LRU[string, CachedApiKey], opts ...func(*ApiKeyAuth)) *ApiKeyAuth {
+	a := ApiKeyAuth{session: session, keys: keys, refresh: time.Minute, miss: 10 * time.Second, used: map[int64]time.Time{}}
+	for _, o := range opts {
+		o(&a)
+	}
+	return &a
+}
+
+func WithApiKeyRefresh(refresh time.Duration) func(*ApiKeyAuth) {
+	return func(a *ApiKeyAuth) {
+		a.refresh = refresh
+	}
+}
+
+// WithApiKeyMiss sets how long an unknown prefix is cached, 10s by default, 0 disables it.
+func WithApiKeyMiss(miss time.Duration) func(*ApiKeyAuth) {
+	return func(a *ApiKeyAuth) {
+		a.miss = miss
+	}
+}
+
+// Authenticate returns the stored key matching the full key.
+func (a *ApiKeyAuth) Authenticate(ctx context.Context, key string) (*repository.ApiKey, error) {
+	prefix, secret, err := repository.ParseApiKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	cached, ok := a.keys.Get(prefix)
+	if ok && cached.Missing && now.Sub(cached.Loaded) < a.miss {
+		return nil, repository.ErrApiKeyNotFound
+	}
+	if !ok || cached.Missing || now.Sub(cached.Loaded) >= a.refresh {
+		entity := repository.ApiKey{Prefix: prefix}
+		if err := entity.ReadByPrefix(a.session, ctx); err != nil {
+			if errors.Is(err, repository.ErrApiKeyNotFound) && a.miss > 0 {
+				a.keys.Add(prefix, CachedApiKey{Loaded: now, Missing: true})
+			}
+			return nil, err
+		}
+
+		cached = &CachedApiKey{Key: entity, Loaded: now}
+		a.keys.Add(prefix, *cached)
+	}
+
+	entity := cached.Key
+	if !entity.Verify(secret) {
+		return nil, repository.ErrApiKeyInvalid
+	}
+	if err := entity.Valid(now); err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.used[entity.Id] = now
+	a.mu.Unlock()
+	return &entity, nil
+}
+
+// Forget drops the cached key, e.g. after it was revoked, rotated or created by this process.
+func (a *ApiKeyAuth) Forget(prefix string) {
+	a.keys.Remove(prefix)
+}
+
+// FlushLastUsed writes the last used times of authenticated keys each interval until ctx is done.
+func (a *ApiKeyAuth) FlushLastUsed(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			a.flush(context.WithoutCancel(ctx), logger)
+			return
+		case <-ticker.C:
+			a.flush(ctx, logger)
+		}
+	}
+}
+
+func (a *ApiKeyAuth) flush(ctx context.Context, logger *slog.Logger) {
+	a.mu.Lock()
+	used := a.used
+	a.used = map[int64]time.Time{}
+	a.mu.Unlock()
+
+	if len(used) == 0 {
+		return
+	}
+
+	if err := repository.TouchApiKeys(a.session, ctx, used); err != nil {
+		logger.ErrorContext(ctx, "api key last used", slog.Int("keys", len(used)), slog.String("error", err.Error()))
+	}
+}
+
+// apiKey reads the key from the X-Api-Key header or a bearer Authorization header.
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// MiddlewareApiKey authenticates requests by api key and sets claims with the key scopes as permissions.
+// Requests without a valid key are answered with 401.
+func MiddlewareApiKey(a *ApiKeyAuth, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := apiKey(r)
+		if key == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		entity, err := a.Authenticate(r.Context(), key)
+		if err != nil {
+			reason := "invalid"
+			switch {
+			case errors.Is(err, repository.ErrApiKeyRevoked):
+				reason = "revoked"
+			case errors.Is(err, repository.ErrApiKeyExpired):
+				reason = "expired"
+			case !errors.Is(err, repository.ErrApiKeyInvalid) && !errors.Is(err, repository.ErrApiKeyNotFound):
+				AddLogAttrs(r.Context(), slog.String("api_key_error", err.Error()))
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			AddLogAttrs(r.Context(), slog.String("api_key_rejected", reason))
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		AddLogAttrs(r.Context(), slog.String("api_key", entity.Prefix))
+		claims := &Claims{
+			Subject:     entity.Name,
+			Permissions: entity.Scopes,
+			Attrs:       map[string]any{"api_key": entity.Prefix},
+		}
+		next.ServeHTTP(w, r.WithContext(SetCtxClaims(r.Context(), claims)))
+	})
+}
@@ -0,0 +1,127 @@
+package api_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api"
+	"github.com/bhmt/tittlemanscrest/cache"
+	"github.com/bhmt/tittlemanscrest/repository"
+	_ "modernc.org/sqlite"
+)
+
+// apiKeyReads counts the api_key lookups of the sqlite_counting driver.
+var apiKeyReads atomic.Int64
+
+type countingDriver struct{ driver.Driver }
+
+func (d countingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{conn}, nil
+}
+
+// countingConn hides the context interfaces of the connection so every query is prepared.
+type countingConn struct{ driver.Conn }
+
+func (c countingConn) Prepare(query string) (driver.Stmt, error) {
+	if strings.Contains(query, "from api_key") {
+		apiKeyReads.Add(1)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func init() {
+	db, _ := sql.Open("sqlite", "")
+	sql.Register("sqlite_counting", countingDriver{db.Driver()})
+}
+
+func TestMiddlewareApiKey(t *testing.T) {
+	session, err := repository.NewSession("sqlite_counting", "file:apikey?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	ctx := context.Background()
+	_, err = session.ExecContext(ctx, `create table api_key (
+		id integer primary key autoincrement not null, name text not null, prefix text unique not null,
+		salt text not null, hash text not null, scopes text not null default '', created_at timestamp not null,
+		expires_at timestamp, revoked_at timestamp, last_used_at timestamp)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entity, key, _ := repository.NewApiKey("billing", []string{"invoices:read"}, time.Time{})
+	if err := entity.Create(session, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, _ := cache.New[string, api.CachedApiKey](10, time.Minute)
+	auth := api.NewApiKeyAuth(session, keys)
+
+	var claims *api.Claims
+	handler := api.MiddlewareApiKey(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ = api.CtxClaims(r.Context())
+	}))
+
+	serve := func(header, value string) int {
+		request := httptest.NewRequest(http.MethodGet, "/invoices", nil)
+		if header != "" {
+			request.Header.Set(header, value)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if got := serve("Authorization", "Bearer "+key); got != http.StatusOK || claims == nil || claims.Permissions[0] != "invoices:read" {
+		t.Fatalf("valid key want=%d with scopes got=%d claims=%+v", http.StatusOK, got, claims)
+	}
+
+	if _, err := entity.Revoke(session, ctx, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if got := serve("X-Api-Key", key); got != http.StatusOK {
+		t.Errorf("cached key want=%d got=%d", http.StatusOK, got)
+	}
+
+	auth.Forget(entity.Prefix)
+	if got := serve("X-Api-Key", key); got != http.StatusUnauthorized {
+		t.Errorf("revoked key want=%d got=%d", http.StatusUnauthorized, got)
+	}
+
+	for _, value := range []string{"", key + "x", "tk_unknown_secret"} {
+		if got := serve("X-Api-Key", value); got != http.StatusUnauthorized {
+			t.Errorf("key %q want=%d got=%d", value, http.StatusUnauthorized, got)
+		}
+	}
+
+	reads := apiKeyReads.Load()
+	for range 3 {
+		if got := serve("X-Api-Key", "tk_unknown_secret"); got != http.StatusUnauthorized {
+			t.Errorf("unknown key want=%d got=%d", http.StatusUnauthorized, got)
+		}
+	}
+	if got := apiKeyReads.Load() - reads; got != 0 {
+		t.Errorf("unknown key should be cached, reads want=0 got=%d", got)
+	}
+
+	flushCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	auth.FlushLastUsed(flushCtx, slog.New(slog.DiscardHandler), time.Hour)
+
+	if err := entity.Read(session, ctx); err != nil || !entity.LastUsedAt.Valid {
+		t.Errorf("last used want set got=%v err=%v", entity.LastUsedAt, err)
+	}
+}
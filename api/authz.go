@@ -0,0 +1,398 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bhmt/tittlemanscrest/repository"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrPolicyNotFound = fmt.Errorf("authorization policy not found")
+	ErrPolicyInvalid  = fmt.Errorf("invalid authorization policy")
+)
+
+// Claims are the authenticated attributes of the caller,
+// set by the authentication middleware with SetCtxClaims.
+type Claims struct {
+	Subject     string
+	Roles       []string
+	Permissions []string
+	Attrs       map[string]any
+}
+
+type claimsContextKeyType struct{}
+
+var claimsContextKey = claimsContextKeyType{}
+
+func SetCtxClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+func CtxClaims(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*Claims)
+	return claims, ok && claims != nil
+}
+
+// Condition compares a claim attribute with a value.
+// Values starting with $header., $query. or $path. are read from the request,
+// e.g. {Attr: "tenant", Op: "eq", Value: "$path.tenant"}.
+// Op is one of eq, ne, in or present.
+// $path. values are only set when the middleware runs inside the ServeMux route.
+type Condition struct {
+	Attr  string `json:"attr" yaml:"attr"`
+	Op    string `json:"op" yaml:"op"`
+	Value any    `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+func Eq(attr string, value any) Condition {
+	return Condition{Attr: attr, Op: "eq", Value: value}
+}
+
+func Ne(attr string, value any) Condition {
+	return Condition{Attr: attr, Op: "ne", Value: value}
+}
+
+func In(attr string, values ...any) Condition {
+	return Condition{Attr: attr, Op: "in", Value: values}
+}
+
+func Present(attr string) Condition {
+	return Condition{Attr: attr, Op: "present"}
+}
+
+// Rule binds requirements to a route pattern, "METHOD /path" or "/path" in path.Match syntax.
+// Public rules allow requests without claims. Otherwise the caller needs the permission,
+// any of the roles and all the conditions, empty requirements are ignored.
+type Rule struct {
+	Route      string      `json:"route" yaml:"route"`
+	Public     bool        `json:"public,omitempty" yaml:"public,omitempty"`
+	Permission string      `json:"permission,omitempty" yaml:"permission,omitempty"`
+	Roles      []string    `json:"roles,omitempty" yaml:"roles,omitempty"`
+	When       []Condition `json:"when,omitempty" yaml:"when,omitempty"`
+}
+
+// Policy maps roles to permissions and routes to rules, the first rule matching a request applies.
+// Permissions granted by roles may use path.Match patterns, e.g. "orders:*".
+// It is built in Go with Role and Route or parsed from YAML or JSON with ParsePolicy.
+type Policy struct {
+	Roles map[string][]string `json:"roles" yaml:"roles"`
+	Rules []Rule              `json:"rules" yaml:"rules"`
+}
+
+func NewPolicy() *Policy {
+	return &Policy{Roles: map[string][]string{}}
+}
+
+func (p *Policy) Role(name string, permissions ...string) *Policy {
+	if p.Roles == nil {
+		p.Roles = map[string][]string{}
+	}
+	p.Roles[name] = append(p.Roles[name], permissions...)
+	return p
+}
+
+// Route adds a rule requiring the permission, an empty permission only requires claims.
+func (p *Policy) Route(pattern string, permission string, when ...Condition) *Policy {
+	p.Rules = append(p.Rules, Rule{Route: pattern, Permission: permission, When: when})
+	return p
+}
+
+func (p *Policy) Public(pattern string) *Policy {
+	p.Rules = append(p.Rules, Rule{Route: pattern, Public: true})
+	return p
+}
+
+// ParsePolicy reads a YAML or JSON policy document.
+func ParsePolicy(data []byte) (*Policy, error) {
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, errors.Join(ErrPolicyInvalid, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Validate reports every malformed rule.
+func (p *Policy) Validate() error {
+	var errs []error
+	for i, rule := range p.Rules {
+		_, pattern := splitRoute(rule.Route)
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			errs = append(errs, fmt.Errorf("rule %d: invalid route %q", i, rule.Route))
+		}
+
+		for _, c := range rule.When {
+			switch c.Op {
+			case "eq", "ne", "in", "present":
+			default:
+				errs = append(errs, fmt.Errorf("rule %d: invalid condition op %q", i, c.Op))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(append([]error{ErrPolicyInvalid}, errs...)...)
+	}
+	return nil
+}
+
+func splitRoute(route string) (method string, pattern string) {
+	if method, pattern, ok := strings.Cut(route, " "); ok {
+		return method, strings.TrimSpace(pattern)
+	}
+	return "", route
+}
+
+// PolicySource loads the policy of an Authorizer.
+type PolicySource interface {
+	Load(ctx context.Context) (*Policy, error)
+}
+
+type PolicySourceFunc func(ctx context.Context) (*Policy, error)
+
+func (f PolicySourceFunc) Load(ctx context.Context) (*Policy, error) {
+	return f(ctx)
+}
+
+// StaticPolicy always loads p, a nil policy fails with ErrPolicyInvalid.
+func StaticPolicy(p *Policy) PolicySource {
+	return PolicySourceFunc(func(ctx context.Context) (*Policy, error) {
+		if p == nil {
+			return nil, fmt.Errorf("%w: nil policy", ErrPolicyInvalid)
+		}
+		return p, p.Validate()
+	})
+}
+
+// FilePolicy reads a YAML or JSON policy file on every load.
+func FilePolicy(name string) PolicySource {
+	return PolicySourceFunc(func(ctx context.Context) (*Policy, error) {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		return ParsePolicy(data)
+	})
+}
+
+// SQLPolicy reads the named policy document from the authz_policy table.
+func SQLPolicy(session *repository.Session, name string) PolicySource {
+	return PolicySourceFunc(func(ctx context.Context) (*Policy, error) {
+		var document string
+		err := session.QueryRowContext(ctx, `select document from authz_policy where name = $1`, name).Scan(&document)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPolicyNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		return ParsePolicy([]byte(document))
+	})
+}
+
+// Decision is the outcome of an authorization check.
+type Decision struct {
+	Allowed bool
+	Rule    string
+	Reason  string
+}
+
+// Authorizer evaluates requests against the policy loaded from its source.
+// Requests without a matching rule are denied.
+type Authorizer struct {
+	source PolicySource
+	dryRun bool
+
+	mu     sync.RWMutex
+	policy *Policy
+}
+
+func NewAuthorizer(ctx context.Context, source PolicySource, opts ...func(*Authorizer)) (*Authorizer, error) {
+	a := Authorizer{source: source}
+	for _, o := range opts {
+		o(&a)
+	}
+
+	if err := a.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// WithDryRun records decisions without denying requests, to audit a policy before enforcing it.
+func WithDryRun() func(*Authorizer) {
+	return func(a *Authorizer) {
+		a.dryRun = true
+	}
+}
+
+// Reload replaces the policy with a fresh load from the source.
+// The current policy is kept when loading fails.
+func (a *Authorizer) Reload(ctx context.Context) error {
+	policy, err := a.source.Load(ctx)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		return fmt.Errorf("%w: source loaded no policy", ErrPolicyInvalid)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.policy = policy
+	return nil
+}
+
+func (a *Authorizer) Authorize(r *http.Request) Decision {
+	a.mu.RLock()
+	policy := a.policy
+	a.mu.RUnlock()
+
+	var rule *Rule
+	for i := range policy.Rules {
+		method, pattern := splitRoute(policy.Rules[i].Route)
+		if method != "" && method != r.Method {
+			continue
+		}
+		if ok, _ := path.Match(pattern, r.URL.Path); ok {
+			rule = &policy.Rules[i]
+			break
+		}
+	}
+
+	if rule == nil {
+		return Decision{Reason: "no matching rule"}
+	}
+
+	d := Decision{Rule: rule.Route}
+	if rule.Public {
+		d.Allowed = true
+		return d
+	}
+
+	claims, ok := CtxClaims(r.Context())
+	switch {
+	case !ok:
+		d.Reason = "no claims"
+	case rule.Permission != "" && !policy.granted(claims, rule.Permission):
+		d.Reason = "missing permission " + rule.Permission
+	case len(rule.Roles) > 0 && !slices.ContainsFunc(rule.Roles, func(role string) bool { return slices.Contains(claims.Roles, role) }):
+		d.Reason = "missing role"
+	default:
+		for _, c := range rule.When {
+			if !c.holds(claims, r) {
+				d.Reason = fmt.Sprintf("condition %s %s failed", c.Attr, c.Op)
+				return d
+			}
+		}
+		d.Allowed = true
+	}
+	return d
+}
+
+func (p *Policy) granted(claims *Claims, permission string) bool {
+	match := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, permission); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	if match(claims.Permissions) {
+		return true
+	}
+	for _, role := range claims.Roles {
+		if match(p.Roles[role]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Condition) holds(claims *Claims, r *http.Request) bool {
+	attr, ok := claims.Attrs[c.Attr]
+	if c.Op == "present" {
+		return ok
+	}
+	if !ok {
+		return false
+	}
+
+	got := fmt.Sprint(attr)
+	switch c.Op {
+	case "eq":
+		return got == requestValue(r, c.Value)
+	case "ne":
+		return got != requestValue(r, c.Value)
+	case "in":
+		values, _ := c.Value.([]any)
+		for _, v := range values {
+			if got == requestValue(r, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestValue resolves $header., $query. and $path. references against the request.
+func requestValue(r *http.Request, value any) string {
+	s := fmt.Sprint(value)
+	switch {
+	case strings.HasPrefix(s, "$header."):
+		return r.Header.Get(strings.TrimPrefix(s, "$header."))
+	case strings.HasPrefix(s, "$query."):
+		return r.URL.Query().Get(strings.TrimPrefix(s, "$query."))
+	case strings.HasPrefix(s, "$path."):
+		return r.PathValue(strings.TrimPrefix(s, "$path."))
+	}
+	return s
+}
+
+// MiddlewareAuthorize enforces the authorizer on every request.
+// Requests without claims are answered with 401 and denied requests with 403,
+// in dry-run mode they are served. Decisions are recorded with AddLogAttrs.
+func MiddlewareAuthorize(a *Authorizer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := a.Authorize(r)
+
+		decision := "allow"
+		if !d.Allowed {
+			decision = "deny"
+		}
+		attrs := []slog.Attr{slog.String("authz_decision", decision), slog.String("authz_rule", d.Rule)}
+		if d.Reason != "" {
+			attrs = append(attrs, slog.String("authz_reason", d.Reason))
+		}
+		if a.dryRun {
+			attrs = append(attrs, slog.Bool("authz_dry_run", true))
+		}
+		AddLogAttrs(r.Context(), attrs...)
+
+		if d.Allowed || a.dryRun {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if _, ok := CtxClaims(r.Context()); !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+}
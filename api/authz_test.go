@@ -0,0 +1,141 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bhmt/tittlemanscrest/api"
+	"github.com/bhmt/tittlemanscrest/repository"
+	_ "modernc.org/sqlite"
+)
+
+func authorize(handler http.Handler, method string, target string, claims *api.Claims, header http.Header) int {
+	request := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		request.Header[k] = v
+	}
+	if claims != nil {
+		request = request.WithContext(api.SetCtxClaims(request.Context(), claims))
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+func TestAuthorizePolicy(t *testing.T) {
+	policy := api.NewPolicy().
+		Role("reader", "orders:read").
+		Role("admin", "orders:*").
+		Public("/health").
+		Route("GET /orders/*", "orders:read", api.Eq("tenant", "$header.X-Tenant")).
+		Route("DELETE /orders/*", "orders:delete")
+
+	authorizer, err := api.NewAuthorizer(context.Background(), api.StaticPolicy(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := api.MiddlewareAuthorize(authorizer, http.HandlerFunc(handlerOk))
+
+	reader := &api.Claims{Subject: "jon", Roles: []string{"reader"}, Attrs: map[string]any{"tenant": "acme"}}
+	admin := &api.Claims{Subject: "daker", Roles: []string{"admin"}}
+	tenant := http.Header{"X-Tenant": {"acme"}}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		claims *api.Claims
+		header http.Header
+		want   int
+	}{
+		{"public", http.MethodGet, "/health", nil, nil, http.StatusOK},
+		{"no claims", http.MethodGet, "/orders/1", nil, tenant, http.StatusUnauthorized},
+		{"role permission", http.MethodGet, "/orders/1", reader, tenant, http.StatusOK},
+		{"attribute mismatch", http.MethodGet, "/orders/1", reader, http.Header{"X-Tenant": {"other"}}, http.StatusForbidden},
+		{"missing permission", http.MethodDelete, "/orders/1", reader, nil, http.StatusForbidden},
+		{"wildcard permission", http.MethodDelete, "/orders/1", admin, nil, http.StatusOK},
+		{"no rule", http.MethodGet, "/users", admin, nil, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		if got := authorize(handler, test.method, test.target, test.claims, test.header); got != test.want {
+			t.Errorf("%s want=%d got=%d", test.name, test.want, got)
+		}
+	}
+
+	dryRun, _ := api.NewAuthorizer(context.Background(), api.StaticPolicy(policy), api.WithDryRun())
+	if got := authorize(api.MiddlewareAuthorize(dryRun, http.HandlerFunc(handlerOk)), http.MethodGet, "/users", nil, nil); got != http.StatusOK {
+		t.Errorf("dry run want=%d got=%d", http.StatusOK, got)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := api.ParsePolicy([]byte(`
+roles:
+  support: [tickets:read]
+rules:
+  - route: GET /tickets/*
+    permission: tickets:read
+    when:
+      - {attr: region, op: in, value: [eu, us]}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorizer, _ := api.NewAuthorizer(context.Background(), api.StaticPolicy(policy))
+	handler := api.MiddlewareAuthorize(authorizer, http.HandlerFunc(handlerOk))
+
+	eu := &api.Claims{Roles: []string{"support"}, Attrs: map[string]any{"region": "eu"}}
+	if got := authorize(handler, http.MethodGet, "/tickets/1", eu, nil); got != http.StatusOK {
+		t.Errorf("yaml policy want=%d got=%d", http.StatusOK, got)
+	}
+
+	_, err = api.ParsePolicy([]byte(`{"rules": [{"route": "/[", "when": [{"attr": "a", "op": "like"}]}]}`))
+	if !errors.Is(err, api.ErrPolicyInvalid) {
+		t.Errorf("invalid policy want=%v got=%v", api.ErrPolicyInvalid, err)
+	}
+
+	if _, err := api.NewAuthorizer(context.Background(), api.StaticPolicy(nil)); !errors.Is(err, api.ErrPolicyInvalid) {
+		t.Errorf("nil policy want=%v got=%v", api.ErrPolicyInvalid, err)
+	}
+}
+
+func TestSQLPolicyReload(t *testing.T) {
+	session, err := repository.NewSession("sqlite", "file:authz?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	ctx := context.Background()
+	session.ExecContext(ctx, `create table authz_policy (name text primary key, document text not null)`)
+	session.ExecContext(ctx, `insert into authz_policy (name, document) values ('api', '{"rules": [{"route": "/a", "public": true}]}')`)
+
+	authorizer, err := api.NewAuthorizer(ctx, api.SQLPolicy(session, "api"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := api.MiddlewareAuthorize(authorizer, http.HandlerFunc(handlerOk))
+
+	if got := authorize(handler, http.MethodGet, "/b", nil, nil); got != http.StatusUnauthorized {
+		t.Errorf("before reload want=%d got=%d", http.StatusUnauthorized, got)
+	}
+
+	session.ExecContext(ctx, `update authz_policy set document = '{"rules": [{"route": "/b", "public": true}]}' where name = 'api'`)
+	if err := authorizer.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := authorize(handler, http.MethodGet, "/b", nil, nil); got != http.StatusOK {
+		t.Errorf("after reload want=%d got=%d", http.StatusOK, got)
+	}
+
+	if _, err := api.NewAuthorizer(ctx, api.SQLPolicy(session, "missing")); !errors.Is(err, api.ErrPolicyNotFound) {
+		t.Errorf("missing policy want=%v got=%v", api.ErrPolicyNotFound, err)
+	}
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fault is injected into a Rate fraction of the requests matching Routes and Header.
+// Routes use path.Match syntax, empty Routes and Header match every request.
+// Latency, plus up to Jitter, delays the request. Then Status answers instead of the handler,
+// Abort closes the connection without a response and Truncate closes it after that many body bytes.
+type Fault struct {
+	Routes   []string
+	Header   string
+	Value    string
+	Rate     float64
+	Latency  time.Duration
+	Jitter   time.Duration
+	Status   int
+	Abort    bool
+	Truncate int
+}
+
+type faultJson struct {
+	Routes   []string `json:"routes,omitempty"`
+	Header   string   `json:"header,omitempty"`
+	Value    string   `json:"value,omitempty"`
+	Rate     float64  `json:"rate"`
+	Latency  string   `json:"latency,omitempty"`
+	Jitter   string   `json:"jitter,omitempty"`
+	Status   int      `json:"status,omitempty"`
+	Abort    bool     `json:"abort,omitempty"`
+	Truncate int      `json:"truncate,omitempty"`
+}
+
+// MarshalJSON writes durations as strings, e.g. "250ms".
+func (f Fault) MarshalJSON() ([]byte, error) {
+	j := faultJson{Routes: f.Routes, Header: f.Header, Value: f.Value, Rate: f.Rate, Status: f.Status, Abort: f.Abort, Truncate: f.Truncate}
+	if f.Latency > 0 {
+		j.Latency = f.Latency.String()
+	}
+	if f.Jitter > 0 {
+		j.Jitter = f.Jitter.String()
+	}
+	return json.Marshal(j)
+}
+
+func (f *Fault) UnmarshalJSON(data []byte) error {
+	var j faultJson
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	*f = Fault{Routes: j.Routes, Header: j.Header, Value: j.Value, Rate: j.Rate, Status: j.Status, Abort: j.Abort, Truncate: j.Truncate}
+	for _, d := range []struct {
+		value  string
+		target *time.Duration
+	}{{j.Latency, &f.Latency}, {j.Jitter, &f.Jitter}} {
+		if d.value == "" {
+			continue
+		}
+
+		var err error
+		if *d.target, err = time.ParseDuration(d.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Fault) matches(r *http.Request) bool {
+	if len(f.Routes) > 0 && !matchAny(f.Routes, r.URL.Path) {
+		return false
+	}
+	if f.Header != "" {
+		v := r.Header.Get(f.Header)
+		if v == "" || (f.Value != "" && v != f.Value) {
+			return false
+		}
+	}
+	return rand.Float64() < f.Rate
+}
+
+// Chaos holds the faults injected by MiddlewareChaos.
+// It starts disabled and injects nothing until enabled with Set or through its admin handler.
+type Chaos struct {
+	mu      sync.RWMutex
+	enabled bool
+	faults  []Fault
+}
+
+func NewChaos() *Chaos {
+	return &Chaos{}
+}
+
+// Set replaces the faults, the first fault matching a request applies.
+func (c *Chaos) Set(enabled bool, faults ...Fault) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enabled = enabled
+	c.faults = faults
+}
+
+func (c *Chaos) fault(r *http.Request) *Fault {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.enabled {
+		return nil
+	}
+	for i := range c.faults {
+		if c.faults[i].matches(r) {
+			f := c.faults[i]
+			return &f
+		}
+	}
+	return nil
+}
+
+type chaosState struct {
+	Enabled bool    `json:"enabled"`
+	Faults  []Fault `json:"faults"`
+}
+
+// ServeHTTP shows the faults on GET, replaces them on PUT and disables injection on DELETE, e.g.
+//
+//	admin.Handle("/debug/chaos", chaos)
+//	curl -X PUT localhost:6060/debug/chaos -d '{"enabled":true,"faults":[{"routes":["/orders/*"],"rate":0.1,"status":503}]}'
+func (c *Chaos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var state chaosState
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&state); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.Set(state.Enabled, state.Faults...)
+	case http.MethodDelete:
+		c.Set(false)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c.mu.RLock()
+	state := chaosState{Enabled: c.enabled, Faults: c.faults}
+	c.mu.RUnlock()
+	writeJson(w, state)
+}
+
+// MiddlewareChaos injects the faults configured on c, it passes requests through while c is disabled.
+// Injected faults are recorded with AddLogAttrs.
+func MiddlewareChaos(c *Chaos, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := c.fault(r)
+		if f == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var injected []string
+		defer func() { AddLogAttrs(r.Context(), slog.String("chaos", strings.Join(injected, ","))) }()
+
+		if delay := f.Latency + randDuration(f.Jitter); delay > 0 {
+			injected = append(injected, "latency")
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		switch {
+		case f.Abort:
+			injected = append(injected, "abort")
+			abort(w)
+		case f.Status != 0:
+			injected = append(injected, "status")
+			http.Error(w, http.StatusText(f.Status), f.Status)
+		case f.Truncate > 0:
+			injected = append(injected, "truncate")
+			i := newBufferedIntercept(w)
+			next.ServeHTTP(i, r)
+
+			body := i.Body.Bytes()
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(i.StatusCode)
+			w.Write(body[:min(f.Truncate, len(body))])
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			abort(w)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func randDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return rand.N(max)
+}
+
+// abort closes the connection, HTTP/2 streams are reset by the server.
+func abort(w http.ResponseWriter) {
+	if h, ok := w.(http.Hijacker); ok {
+		if conn, _, err := h.Hijack(); err == nil {
+			conn.Close()
+			return
+		}
+	}
+	panic(http.ErrAbortHandler)
+}
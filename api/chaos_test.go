@@ -0,0 +1,73 @@
+package api_test
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api"
+)
+
+func TestMiddlewareChaos(t *testing.T) {
+	chaos := api.NewChaos()
+	body := strings.Repeat("antigravity ", 10)
+	handler := api.MiddlewareBase(slog.New(slog.DiscardHandler), api.MiddlewareChaos(chaos,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, body)
+		}),
+	))
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	get := func(header http.Header) (*http.Response, string, error) {
+		request, _ := http.NewRequest(http.MethodGet, server.URL+"/orders/1", nil)
+		request.Header = header
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		return resp, string(data), err
+	}
+
+	chaos.Set(false, api.Fault{Rate: 1, Status: http.StatusTeapot})
+	if resp, got, err := get(nil); err != nil || resp.StatusCode != http.StatusOK || got != body {
+		t.Fatalf("disabled chaos should be inert got=%v %q err=%v", resp, got, err)
+	}
+
+	admin := httptest.NewRecorder()
+	chaos.ServeHTTP(admin, httptest.NewRequest(http.MethodPut, "/debug/chaos", strings.NewReader(
+		`{"enabled":true,"faults":[
+			{"header":"X-Chaos","value":"abort","rate":1,"abort":true},
+			{"header":"X-Chaos","value":"truncate","rate":1,"truncate":5},
+			{"routes":["/orders/*"],"rate":1,"latency":"30ms","status":503}
+		]}`,
+	)))
+	if admin.Code != http.StatusOK || !strings.Contains(admin.Body.String(), `"latency": "30ms"`) {
+		t.Fatalf("admin toggle want=%d got=%d %s", http.StatusOK, admin.Code, admin.Body.String())
+	}
+
+	start := time.Now()
+	if resp, _, err := get(nil); err != nil || resp.StatusCode != http.StatusServiceUnavailable || time.Since(start) < 30*time.Millisecond {
+		t.Errorf("status fault want=%d after latency got=%v err=%v", http.StatusServiceUnavailable, resp, err)
+	}
+
+	if _, _, err := get(http.Header{"X-Chaos": {"abort"}}); err == nil {
+		t.Error("abort fault should fail the request")
+	}
+
+	if _, got, err := get(http.Header{"X-Chaos": {"truncate"}}); err == nil || got != body[:5] {
+		t.Errorf("truncate fault want=%q with error got=%q err=%v", body[:5], got, err)
+	}
+
+	chaos.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/debug/chaos", nil))
+	if resp, _, err := get(nil); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("deleted chaos should be inert got=%v err=%v", resp, err)
+	}
+}
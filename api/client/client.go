@@ -0,0 +1,275 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api/helper"
+)
+
+var (
+	ErrCircuitOpen = fmt.Errorf("circuit open")
+	ErrNoReplay    = fmt.Errorf("request body can not be replayed")
+)
+
+// Client wraps http.Client with retries, per host circuit breakers and per attempt timeouts.
+// The request id and trace context stored by api.MiddlewareBase are propagated as headers.
+type Client struct {
+	http             *http.Client
+	logger           *slog.Logger
+	retries          int
+	backoffMin       time.Duration
+	backoffMax       time.Duration
+	attemptTimeout   time.Duration
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+func New(opts ...func(*Client)) *Client {
+	c := Client{
+		http:             http.DefaultClient,
+		logger:           slog.Default(),
+		retries:          2,
+		backoffMin:       100 * time.Millisecond,
+		backoffMax:       2 * time.Second,
+		breakerThreshold: 5,
+		breakerCooldown:  10 * time.Second,
+		breakers:         map[string]*breaker{},
+	}
+
+	for _, o := range opts {
+		o(&c)
+	}
+
+	return &c
+}
+
+func WithHTTPClient(val *http.Client) func(*Client) {
+	return func(c *Client) {
+		c.http = val
+	}
+}
+
+func WithLogger(val *slog.Logger) func(*Client) {
+	return func(c *Client) {
+		c.logger = val
+	}
+}
+
+func WithRetries(val int) func(*Client) {
+	return func(c *Client) {
+		c.retries = val
+	}
+}
+
+func WithBackoff(min, max time.Duration) func(*Client) {
+	return func(c *Client) {
+		c.backoffMin = min
+		c.backoffMax = max
+	}
+}
+
+func WithAttemptTimeout(val time.Duration) func(*Client) {
+	return func(c *Client) {
+		c.attemptTimeout = val
+	}
+}
+
+func WithBreaker(threshold int, cooldown time.Duration) func(*Client) {
+	return func(c *Client) {
+		c.breakerThreshold = threshold
+		c.breakerCooldown = cooldown
+	}
+}
+
+func (c *Client) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends the request, retrying idempotent methods on transport errors and on
+// 429, 502, 503 and 504 responses. Retry-After is honored up to the maximum backoff,
+// otherwise the wait is an exponential backoff with full jitter.
+// Requests cancelled by the caller do not count as breaker failures.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	req = req.Clone(ctx)
+	helper.SetHeaderRequestId(req.Header, helper.CtxRequestId(ctx))
+	helper.SetHeaderTrace(req.Header, helper.CtxTrace(ctx))
+
+	b := c.breaker(req.URL.Host)
+	retries := c.retries
+	if !idempotent(req.Method) {
+		retries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		if !b.allow() {
+			c.log(ctx, slog.LevelWarn, req, attempt, 0, nil, ErrCircuitOpen)
+			return nil, ErrCircuitOpen
+		}
+
+		if attempt > 0 && req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, ErrNoReplay
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		start := time.Now()
+		resp, err := c.attempt(req)
+		if err != nil && ctx.Err() != nil {
+			// The caller gave up, the host did not fail.
+			b.cancel()
+		} else {
+			b.record(err != nil || resp.StatusCode >= http.StatusInternalServerError)
+		}
+
+		if ctx.Err() != nil || attempt >= retries || !retryable(resp, err) {
+			c.log(ctx, slog.LevelInfo, req, attempt, time.Since(start), resp, err)
+			return resp, err
+		}
+
+		wait := c.backoff(attempt)
+		if resp != nil {
+			if d, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				wait = min(d, c.backoffMax)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		c.log(ctx, slog.LevelWarn, req, attempt, time.Since(start), resp, err, slog.Duration("retry_in", wait))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) attempt(req *http.Request) (*http.Response, error) {
+	if c.attemptTimeout == 0 {
+		return c.http.Do(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), c.attemptTimeout)
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (c *Client) breaker(host string) *breaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, ok := c.breakers[host]
+	if !ok {
+		b = &breaker{threshold: c.breakerThreshold, cooldown: c.breakerCooldown}
+		c.breakers[host] = b
+	}
+	return b
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.backoffMin << attempt
+	if d <= 0 || d > c.backoffMax {
+		d = c.backoffMax
+	}
+	return rand.N(d) + 1
+}
+
+func (c *Client) log(ctx context.Context, level slog.Level, req *http.Request, attempt int, duration time.Duration, resp *http.Response, err error, extra ...slog.Attr) {
+	attrs := []slog.Attr{
+		slog.String("request_id", req.Header.Get("X-Request-Id")),
+		slog.Time("time", time.Now().UTC()),
+		slog.String("method", req.Method),
+		slog.String("host", req.URL.Host),
+		slog.String("path", req.URL.Path),
+		slog.Int("attempt", attempt),
+		slog.Duration("duration", duration),
+	}
+
+	if resp != nil {
+		attrs = append(attrs, slog.Int("status", resp.StatusCode))
+	}
+
+	if err != nil {
+		level = max(level, slog.LevelWarn)
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+
+	c.logger.LogAttrs(ctx, level, "outbound", append(attrs, extra...)...)
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func retryAfter(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+
+	if s, err := strconv.Atoi(header); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(header); err == nil {
+		return max(0, time.Until(t)), true
+	}
+
+	return 0, false
+}
+
+// cancelBody releases the attempt context once the caller is done with the body.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
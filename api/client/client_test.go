@@ -0,0 +1,136 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api/client"
+	"github.com/bhmt/tittlemanscrest/api/helper"
+)
+
+var logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestClientRetry(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(r.Header.Get("X-Request-Id")))
+	}))
+	defer server.Close()
+
+	c := client.New(client.WithLogger(logger), client.WithRetries(2))
+	ctx := helper.SetCtxRequestId(context.Background(), "antigravity")
+
+	resp, err := c.Get(ctx, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(data) != "antigravity" {
+		t.Errorf("client retry mismatch, status=%d body=%q", resp.StatusCode, data)
+	}
+
+	if got := calls.Load(); got != 3 {
+		t.Errorf("client attempts want=3 got=%d", got)
+	}
+}
+
+func TestClientNoRetryPost(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := client.New(client.WithLogger(logger), client.WithBackoff(time.Millisecond, time.Millisecond))
+	req, _ := http.NewRequest(http.MethodPost, server.URL, nil)
+
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("client post attempts want=1 got=%d", got)
+	}
+}
+
+func TestClientBreaker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	c := client.New(
+		client.WithLogger(logger),
+		client.WithRetries(0),
+		client.WithBreaker(2, time.Minute),
+	)
+
+	for range 2 {
+		resp, err := c.Get(context.Background(), server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if _, err := c.Get(context.Background(), server.URL); err != client.ErrCircuitOpen {
+		t.Errorf("client breaker want=%v got=%v", client.ErrCircuitOpen, err)
+	}
+}
+
+func TestClientRetryAfterClamp(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	c := client.New(client.WithLogger(logger), client.WithRetries(1), client.WithBackoff(time.Millisecond, 10*time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	resp, err := c.Get(ctx, server.URL)
+	if err != nil {
+		t.Fatalf("retry after should be clamped to the maximum backoff got=%v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestClientBreakerCancel(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+	defer close(block)
+
+	c := client.New(client.WithLogger(logger), client.WithRetries(0), client.WithBreaker(1, time.Minute))
+	for range 2 {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := c.Get(ctx, server.URL)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("cancelled request want=DeadlineExceeded got=%v", err)
+		}
+	}
+}
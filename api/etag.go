@@ -0,0 +1,105 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MiddlewareETag buffers GET and HEAD responses to compute an ETag from the body.
+// HEAD requests are served as GET so their ETag matches, the body is then dropped.
+// Handlers that set their own ETag keep it.
+// Conditional requests matching the ETag or Last-Modified headers are answered with 304.
+func MiddlewareETag(weak bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		head := r.Method == http.MethodHead
+		if head {
+			r = r.Clone(r.Context())
+			r.Method = http.MethodGet
+		}
+
+		i := newBufferedIntercept(w)
+		next.ServeHTTP(i, r)
+
+		if i.StatusCode == http.StatusOK && w.Header().Get("ETag") == "" {
+			w.Header().Set("ETag", etag(i.Body.Bytes(), weak))
+		}
+		if head {
+			i.Body.Reset()
+		}
+
+		if i.StatusCode == http.StatusOK && notModified(r, w.Header()) {
+			writeNotModified(w)
+			return
+		}
+
+		i.writeTo(w)
+	})
+}
+
+func etag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	tag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// notModified evaluates If-None-Match and, in its absence, If-Modified-Since
+// against the response headers.
+func notModified(r *http.Request, h http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatch(inm, h.Get("ETag"))
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	lm := h.Get("Last-Modified")
+	if ims == "" || lm == "" {
+		return false
+	}
+
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+
+	modified, err := http.ParseTime(lm)
+	if err != nil {
+		return false
+	}
+
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// etagMatch uses the weak comparison required for If-None-Match.
+func etagMatch(header string, tag string) bool {
+	if tag == "" {
+		return false
+	}
+
+	tag = strings.TrimPrefix(tag, "W/")
+	for candidate := range strings.SplitSeq(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	w.WriteHeader(http.StatusNotModified)
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api/helper"
+	"github.com/bhmt/tittlemanscrest/flags"
+)
+
+type flagsContextKeyType struct{}
+
+var flagsContextKey = flagsContextKeyType{}
+
+type flagsContext struct {
+	store  *flags.Store
+	target flags.Target
+}
+
+// FlagTarget evaluates flags for the request claims subject, anonymous requests are bucketed by the peer address.
+// Attribute keys starting with header: are read from the request headers, other keys from the claims.
+func FlagTarget(r *http.Request) flags.Target {
+	claims, _ := CtxClaims(r.Context())
+
+	t := flags.Target{Lookup: func(key string) string {
+		if name, ok := strings.CutPrefix(key, "header:"); ok {
+			return r.Header.Get(name)
+		}
+		if claims == nil {
+			return ""
+		}
+		if v, ok := claims.Attrs[key]; ok {
+			return fmt.Sprint(v)
+		}
+		return ""
+	}}
+
+	if claims != nil {
+		t.Subject = claims.Subject
+	}
+	if t.Subject == "" {
+		t.Key = helper.RemoteIp(r)
+	}
+	return t
+}
+
+// MiddlewareFlags makes the store available to FlagOn.
+// It runs after authentication so targeting can use the claims.
+func MiddlewareFlags(store *flags.Store, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), flagsContextKey, &flagsContext{store: store, target: FlagTarget(r)})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// FlagOn reports whether the flag is on for the request,
+// it is false when the request is not served through MiddlewareFlags.
+func FlagOn(ctx context.Context, name string) bool {
+	f, ok := ctx.Value(flagsContextKey).(*flagsContext)
+	return ok && f.store.On(name, f.target)
+}
+
+// MiddlewareFeature answers 404 while the flag is off for the request, hiding unreleased routes.
+func MiddlewareFeature(store *flags.Store, name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !store.On(name, FlagTarget(r)) {
+			AddLogAttrs(r.Context(), slog.String("flag_off", name))
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MiddlewareMaintenance answers 503 with Retry-After while the flag is on,
+// wrap the whole api or single routes with their own flag.
+func MiddlewareMaintenance(store *flags.Store, name string, retryAfter time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if store.On(name, FlagTarget(r)) {
+			AddLogAttrs(r.Context(), slog.String("maintenance", name))
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+			http.Error(w, "service under maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// FlagsHandler lists the flags on GET and replaces a flag on PUT with its JSON definition, e.g.
+//
+//	admin.Handle("/debug/flags", api.FlagsHandler(store))
+//
+// Changes last until the store is reloaded.
+func FlagsHandler(store *flags.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJson(w, store.All())
+		case http.MethodPut:
+			var f flags.Flag
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&f); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := store.Put(f); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJson(w, f)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api"
+	"github.com/bhmt/tittlemanscrest/flags"
+)
+
+func TestMiddlewareFlags(t *testing.T) {
+	store, _ := flags.NewStore(context.Background(), flags.Memory(
+		flags.Flag{Name: "maintenance"},
+		flags.Flag{Name: "beta", Enabled: true, Attrs: map[string][]string{"header:X-Beta": {"1"}}},
+	))
+
+	var beta bool
+	handler := api.MiddlewareMaintenance(store, "maintenance", time.Minute, api.MiddlewareFlags(store,
+		api.MiddlewareFeature(store, "beta", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			beta = api.FlagOn(r.Context(), "beta")
+		})),
+	))
+
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/beta", nil)
+		request.Header = header
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if got := serve(http.Header{}).Code; got != http.StatusNotFound {
+		t.Errorf("feature off want=%d got=%d", http.StatusNotFound, got)
+	}
+
+	if got := serve(http.Header{"X-Beta": {"1"}}).Code; got != http.StatusOK || !beta {
+		t.Errorf("targeted feature want=%d got=%d flag=%v", http.StatusOK, got, beta)
+	}
+
+	admin := api.FlagsHandler(store)
+	recorder := httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/debug/flags", strings.NewReader(`{"name":"maintenance","enabled":true}`)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("toggle want=%d got=%d %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+
+	if got := serve(http.Header{"X-Beta": {"1"}}); got.Code != http.StatusServiceUnavailable || got.Header().Get("Retry-After") != "60" {
+		t.Errorf("maintenance want=%d got=%d retry-after=%s", http.StatusServiceUnavailable, got.Code, got.Header().Get("Retry-After"))
+	}
+}
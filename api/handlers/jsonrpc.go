@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/logging"
+)
+
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+)
+
+// RPCError is a JSON-RPC 2.0 error object.
+// Methods returning it control the code sent to the client,
+// any other error is sent as an internal error.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	Id      json.RawMessage `json:"id"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+type rpcMethod func(ctx context.Context, params json.RawMessage) (any, error)
+
+// RPC is a registry of JSON-RPC 2.0 methods independent of the transport.
+type RPC struct {
+	mu      sync.RWMutex
+	methods map[string]rpcMethod
+}
+
+func NewRPC() *RPC {
+	return &RPC{methods: map[string]rpcMethod{}}
+}
+
+// Register adds a typed method, params are decoded into P and the result is encoded as JSON.
+func Register[P, R any](rpc *RPC, name string, fn func(ctx context.Context, params P) (R, error)) {
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+
+	rpc.methods[name] = func(ctx context.Context, raw json.RawMessage) (any, error) {
+		var params P
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &params); err != nil {
+				return nil, &RPCError{Code: RPCInvalidParams, Message: err.Error()}
+			}
+		}
+		return fn(ctx, params)
+	}
+}
+
+// Handle processes a single or batch payload.
+// It returns nil when there is nothing to send back, e.g. for notifications.
+func (rpc *RPC) Handle(ctx context.Context, payload []byte) []byte {
+	payload = bytes.TrimSpace(payload)
+
+	if len(payload) == 0 || payload[0] != '[' {
+		if !json.Valid(payload) {
+			return marshalRPC(rpcResponse{Error: &RPCError{Code: RPCParseError, Message: "parse error"}})
+		}
+
+		// Valid JSON that is not a request object, e.g. a number.
+		var req rpcRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return marshalRPC(rpcResponse{Error: &RPCError{Code: RPCInvalidRequest, Message: "invalid request"}})
+		}
+
+		resp := rpc.call(ctx, req)
+		if resp == nil {
+			return nil
+		}
+		return marshalRPC(*resp)
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(payload, &batch); err != nil {
+		return marshalRPC(rpcResponse{Error: &RPCError{Code: RPCParseError, Message: "parse error"}})
+	}
+
+	if len(batch) == 0 {
+		return marshalRPC(rpcResponse{Error: &RPCError{Code: RPCInvalidRequest, Message: "empty batch"}})
+	}
+
+	var responses []rpcResponse
+	for _, raw := range batch {
+		var req rpcRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			responses = append(responses, rpcResponse{Error: &RPCError{Code: RPCInvalidRequest, Message: "invalid request"}})
+			continue
+		}
+
+		if resp := rpc.call(ctx, req); resp != nil {
+			responses = append(responses, *resp)
+		}
+	}
+
+	if len(responses) == 0 {
+		return nil
+	}
+
+	for i := range responses {
+		responses[i].JSONRPC = "2.0"
+		if responses[i].Id == nil {
+			responses[i].Id = json.RawMessage("null")
+		}
+	}
+
+	data, _ := json.Marshal(responses)
+	return data
+}
+
+// Pipe handles every payload received on in and sends the responses on the returned channel,
+// e.g. for JSONRPCStream. The channel is closed once in is closed or ctx is done.
+func (rpc *RPC) Pipe(ctx context.Context, in <-chan []byte) <-chan []byte {
+	out := make(chan []byte)
+
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case payload, ok := <-in:
+				if !ok {
+					return
+				}
+
+				resp := rpc.Handle(ctx, payload)
+				if resp == nil {
+					continue
+				}
+
+				select {
+				case out <- resp:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// call returns nil for notifications, requests without an id.
+// Every call is logged through logging.FromContext, a payload may hold many of them.
+func (rpc *RPC) call(ctx context.Context, req rpcRequest) *rpcResponse {
+	notification := req.Id == nil
+	resp := rpcResponse{Id: req.Id}
+
+	start := time.Now()
+	defer func() {
+		attrs := []slog.Attr{slog.String("rpc_method", req.Method), slog.Duration("duration", time.Since(start))}
+		if req.Id != nil {
+			attrs = append(attrs, slog.String("rpc_id", string(req.Id)))
+		}
+		if resp.Error != nil {
+			attrs = append(attrs, slog.Int("rpc_error", resp.Error.Code))
+		}
+		logging.FromContext(ctx).LogAttrs(ctx, slog.LevelInfo, "rpc call", attrs...)
+	}()
+
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		resp.Error = &RPCError{Code: RPCInvalidRequest, Message: "invalid request"}
+		return &resp
+	}
+
+	rpc.mu.RLock()
+	method, ok := rpc.methods[req.Method]
+	rpc.mu.RUnlock()
+
+	if !ok {
+		resp.Error = &RPCError{Code: RPCMethodNotFound, Message: "method not found"}
+	} else if result, err := method(ctx, req.Params); err != nil {
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &RPCError{Code: RPCInternalError, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+	} else if resp.Result, err = json.Marshal(result); err != nil {
+		resp.Error = &RPCError{Code: RPCInternalError, Message: err.Error()}
+	}
+
+	if notification {
+		return nil
+	}
+	return &resp
+}
+
+func marshalRPC(resp rpcResponse) []byte {
+	resp.JSONRPC = "2.0"
+	if resp.Id == nil {
+		resp.Id = json.RawMessage("null")
+	}
+
+	data, _ := json.Marshal(resp)
+	return data
+}
+
+// JSONRPC serves the registry over HTTP POST.
+// Requests consisting only of notifications are answered with 204.
+func JSONRPC(rpc *RPC) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := rpc.Handle(r.Context(), payload)
+		if resp == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+	}
+}
+
+// JSONRPCStream serves the registry over a streaming HTTP POST.
+// The request body carries one payload per line and each response is sent back
+// as a server sent event once ready, liveliness keeps the stream open meanwhile.
+// The stream ends once the body is read and every response is sent.
+func JSONRPCStream(rpc *RPC, liveliness time.Duration) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// HTTP/1 responses are written while the body is still read.
+		http.NewResponseController(w).EnableFullDuplex()
+
+		ctx, cancel := context.WithCancel(r.Context())
+		in := make(chan []byte)
+		read := make(chan struct{})
+		go func() {
+			defer close(read)
+			defer close(in)
+
+			scanner := bufio.NewScanner(r.Body)
+			scanner.Buffer(nil, 1<<20)
+			for scanner.Scan() {
+				payload := bytes.TrimSpace(scanner.Bytes())
+				if len(payload) == 0 {
+					continue
+				}
+
+				select {
+				case in <- bytes.Clone(payload):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		// The body must not be read once the handler returns.
+		defer func() { <-read }()
+		defer cancel()
+
+		ServerSentEvents(func() <-chan []byte { return rpc.Pipe(ctx, in) }, liveliness)(w, r)
+	}
+}
@@ -0,0 +1,161 @@
+package handlers_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api/handlers"
+)
+
+type sumParams struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func newRPC() (*handlers.RPC, chan string) {
+	notified := make(chan string, 2)
+
+	rpc := handlers.NewRPC()
+	handlers.Register(rpc, "sum", func(ctx context.Context, p sumParams) (int, error) {
+		return p.A + p.B, nil
+	})
+	handlers.Register(rpc, "notify", func(ctx context.Context, p string) (any, error) {
+		notified <- p
+		return nil, nil
+	})
+	handlers.Register(rpc, "fail", func(ctx context.Context, p any) (any, error) {
+		return nil, fmt.Errorf("antigravity")
+	})
+
+	return rpc, notified
+}
+
+func TestJSONRPC(t *testing.T) {
+	rpc, notified := newRPC()
+	handler := handlers.JSONRPC(rpc)
+
+	tests := []struct {
+		body       string
+		wantStatus int
+		want       string
+	}{
+		{
+			body:       `{"jsonrpc":"2.0","method":"sum","params":{"a":1,"b":2},"id":1}`,
+			wantStatus: http.StatusOK,
+			want:       `{"jsonrpc":"2.0","result":3,"id":1}`,
+		},
+		{
+			body:       `{"jsonrpc":"2.0","method":"notify","params":"hello"}`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			body:       `{"jsonrpc":"2.0","method":"missing","id":"a"}`,
+			wantStatus: http.StatusOK,
+			want:       `{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found"},"id":"a"}`,
+		},
+		{
+			body:       `{"jsonrpc":"2.0","method":"sum","params":[1,2],"id":2}`,
+			wantStatus: http.StatusOK,
+			want:       `"code":-32602`,
+		},
+		{
+			body:       `{"jsonrpc":"2.0","method":"fail","id":3}`,
+			wantStatus: http.StatusOK,
+			want:       `{"jsonrpc":"2.0","error":{"code":-32603,"message":"antigravity"},"id":3}`,
+		},
+		{
+			body:       `{"jsonrpc":`,
+			wantStatus: http.StatusOK,
+			want:       `{"jsonrpc":"2.0","error":{"code":-32700,"message":"parse error"},"id":null}`,
+		},
+		{
+			body:       `1`,
+			wantStatus: http.StatusOK,
+			want:       `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":null}`,
+		},
+		{
+			body:       `[{"jsonrpc":"2.0","method":"sum","params":{"a":2,"b":2},"id":1},{"jsonrpc":"2.0","method":"notify","params":"batch"},1]`,
+			wantStatus: http.StatusOK,
+			want:       `[{"jsonrpc":"2.0","result":4,"id":1},{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":null}]`,
+		},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(test.body)))
+
+		if recorder.Code != test.wantStatus || !strings.Contains(recorder.Body.String(), test.want) {
+			t.Errorf("jsonrpc %s\nwant=%d %s\ngot=%d %s", test.body, test.wantStatus, test.want, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	for _, want := range []string{"hello", "batch"} {
+		select {
+		case got := <-notified:
+			if got != want {
+				t.Errorf("jsonrpc notification want=%s got=%s", want, got)
+			}
+		default:
+			t.Errorf("jsonrpc notification %s not delivered", want)
+		}
+	}
+}
+
+func TestJSONRPCPipe(t *testing.T) {
+	rpc, _ := newRPC()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := make(chan []byte, 2)
+	in <- []byte(`{"jsonrpc":"2.0","method":"notify","params":"pipe"}`)
+	in <- []byte(`{"jsonrpc":"2.0","method":"sum","params":{"a":20,"b":22},"id":7}`)
+
+	select {
+	case got := <-rpc.Pipe(ctx, in):
+		if want := `{"jsonrpc":"2.0","result":42,"id":7}`; string(got) != want {
+			t.Errorf("jsonrpc pipe want=%s got=%s", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Error("jsonrpc pipe timeout")
+	}
+}
+
+func TestJSONRPCStream(t *testing.T) {
+	rpc, notified := newRPC()
+	server := httptest.NewServer(http.HandlerFunc(handlers.JSONRPCStream(rpc, time.Minute)))
+	defer server.Close()
+
+	body := `{"jsonrpc":"2.0","method":"notify","params":"stream"}
+
+{"jsonrpc":"2.0","method":"sum","params":{"a":1,"b":2},"id":1}
+[{"jsonrpc":"2.0","method":"sum","params":{"a":20,"b":22},"id":2}]
+`
+	resp, err := http.Post(server.URL, "application/x-ndjson", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("jsonrpc stream content type want=text/event-stream got=%s", got)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "data: {\"jsonrpc\":\"2.0\",\"result\":3,\"id\":1}\n\n" +
+		"data: [{\"jsonrpc\":\"2.0\",\"result\":42,\"id\":2}]\n\n"
+	if string(data) != want {
+		t.Errorf("jsonrpc stream want=%q got=%q", want, data)
+	}
+	if got := <-notified; got != "stream" {
+		t.Errorf("jsonrpc stream notification want=stream got=%s", got)
+	}
+}
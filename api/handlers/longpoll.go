@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrEventBufferSize = fmt.Errorf("event buffer size must be positive")
+
+// Event is a message of an EventBuffer, ids increase by one per message.
+type Event struct {
+	Id   uint64 `json:"id"`
+	Data string `json:"data"`
+}
+
+// EventBuffer reads an event source and keeps the latest events with sequential ids,
+// so clients can resume from a cursor with LongPoll or ServerSentEventsBuffer.
+type EventBuffer struct {
+	mu     sync.Mutex
+	events []Event
+	size   int
+	last   uint64
+	closed bool
+	notify chan struct{}
+}
+
+// NewEventBuffer consumes the source until it is closed or ctx is done, keeping up to size events.
+func NewEventBuffer(ctx context.Context, fn func() <-chan []byte, size int) (*EventBuffer, error) {
+	if size <= 0 {
+		return nil, ErrEventBufferSize
+	}
+
+	b := EventBuffer{size: size, notify: make(chan struct{})}
+	source := fn()
+
+	go func() {
+		defer b.close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-source:
+				if !ok {
+					return
+				}
+				b.add(msg)
+			}
+		}
+	}()
+
+	return &b, nil
+}
+
+func (b *EventBuffer) add(msg []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.last++
+	b.events = append(b.events, Event{Id: b.last, Data: string(msg)})
+	if len(b.events) > b.size {
+		b.events = b.events[len(b.events)-b.size:]
+	}
+
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+func (b *EventBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	close(b.notify)
+}
+
+// Last returns the id of the latest event, the cursor of a client starting now.
+func (b *EventBuffer) Last() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.last
+}
+
+// Stale reports whether events after the cursor were already dropped from the buffer,
+// the client missed them and has to resync.
+func (b *EventBuffer) Stale(cursor uint64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.events) > 0 && cursor+1 < b.events[0].Id
+}
+
+// Since returns up to max events after the cursor, all of them when max is 0.
+// When no events are available, wait is closed on the next event.
+// A stale cursor resumes from the oldest kept event, see Stale.
+func (b *EventBuffer) Since(cursor uint64, max int) (events []Event, wait <-chan struct{}, closed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, e := range b.events {
+		if e.Id > cursor {
+			events = append(events, e)
+			if len(events) == max {
+				break
+			}
+		}
+	}
+
+	return events, b.notify, b.closed
+}
+
+type longPollResponse struct {
+	Events []Event `json:"events"`
+	Cursor uint64  `json:"cursor"`
+}
+
+// LongPoll holds the request until events after the cursor query parameter are available
+// or timeout passes, and returns up to max events with the cursor for the next poll.
+// Without a cursor the client starts from the latest event, as a new ServerSentEvents connection would.
+// A cursor ahead of the latest event is answered at once with the latest cursor,
+// a stale cursor with 410 Gone and the latest cursor to resume from once the client resynced.
+func LongPoll(buf *EventBuffer, timeout time.Duration, max int) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cursor := buf.Last()
+		if c := r.URL.Query().Get("cursor"); c != "" {
+			var err error
+			if cursor, err = strconv.ParseUint(c, 10, 64); err != nil {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// A cursor ahead of the buffer was issued before a restart,
+		// the client is answered at once with the cursor to resume from.
+		events := []Event{}
+		if last := buf.Last(); cursor > last {
+			writeLongPoll(w, http.StatusOK, events, last)
+			return
+		}
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+	poll:
+		for {
+			if buf.Stale(cursor) {
+				writeLongPoll(w, http.StatusGone, events, buf.Last())
+				return
+			}
+
+			found, wait, closed := buf.Since(cursor, max)
+			if len(found) > 0 {
+				events = found
+				cursor = found[len(found)-1].Id
+				break
+			}
+
+			if closed {
+				http.Error(w, "err reading message", http.StatusInternalServerError)
+				return
+			}
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-timer.C:
+				break poll
+			case <-wait:
+			}
+		}
+
+		writeLongPoll(w, http.StatusOK, events, cursor)
+	}
+}
+
+func writeLongPoll(w http.ResponseWriter, status int, events []Event, cursor uint64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(longPollResponse{Events: events, Cursor: cursor})
+}
+
+// ServerSentEventsBuffer streams the buffer with event ids,
+// resuming after the Last-Event-ID header when the client reconnects.
+// A client falling behind the buffer gets a reset event carrying the latest id,
+// it has to resync and the stream resumes from there.
+func ServerSentEventsBuffer(buf *EventBuffer, liveliness time.Duration) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// A Last-Event-ID ahead of the buffer was issued before a restart and resumes from now.
+		cursor := buf.Last()
+		if id, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil && id < cursor {
+			cursor = id
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		serveEvents(w, r, liveliness, func() <-chan []byte { return bufferFrames(ctx, buf, cursor) }, func(w io.Writer, frame []byte) error {
+			_, err := w.Write(frame)
+			return err
+		})
+	}
+}
+
+// bufferFrames formats the events after the cursor as server sent events
+// until the buffer is closed or ctx is done.
+func bufferFrames(ctx context.Context, buf *EventBuffer, cursor uint64) <-chan []byte {
+	out := make(chan []byte)
+
+	send := func(frame string) bool {
+		select {
+		case out <- []byte(frame):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	go func() {
+		defer close(out)
+		for {
+			if buf.Stale(cursor) {
+				cursor = buf.Last()
+				if !send(fmt.Sprintf("id: %d\nevent: reset\ndata: %d\n\n", cursor, cursor)) {
+					return
+				}
+			}
+
+			events, wait, closed := buf.Since(cursor, 0)
+			for _, e := range events {
+				if !send(fmt.Sprintf("id: %d\ndata: %s\n\n", e.Id, e.Data)) {
+					return
+				}
+				cursor = e.Id
+			}
+			if len(events) > 0 {
+				continue
+			}
+
+			if closed {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-wait:
+			}
+		}
+	}()
+
+	return out
+}
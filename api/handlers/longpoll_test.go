@@ -0,0 +1,144 @@
+package handlers_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api/handlers"
+)
+
+type longPollBody struct {
+	Events []handlers.Event `json:"events"`
+	Cursor uint64           `json:"cursor"`
+}
+
+func poll(t *testing.T, handler func(http.ResponseWriter, *http.Request), target string) (int, longPollBody) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+
+	var body longPollBody
+	if recorder.Code == http.StatusOK || recorder.Code == http.StatusGone {
+		if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return recorder.Code, body
+}
+
+func TestLongPoll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := handlers.NewEventBuffer(ctx, func() <-chan []byte { return nil }, 0); !errors.Is(err, handlers.ErrEventBufferSize) {
+		t.Errorf("zero size want=ErrEventBufferSize got=%v", err)
+	}
+
+	source := make(chan []byte)
+	buf, err := handlers.NewEventBuffer(ctx, func() <-chan []byte { return source }, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := handlers.LongPoll(buf, 50*time.Millisecond, 10)
+
+	if status, got := poll(t, handler, "/poll"); status != http.StatusOK || len(got.Events) != 0 || got.Cursor != 0 {
+		t.Errorf("long poll timeout want empty batch got=%d %+v", status, got)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		source <- []byte("first")
+	}()
+
+	status, got := poll(t, handler, "/poll")
+	if status != http.StatusOK || len(got.Events) != 1 || got.Events[0].Data != "first" || got.Cursor != 1 {
+		t.Fatalf("long poll held request want first event got=%d %+v", status, got)
+	}
+
+	for _, msg := range []string{"second", "third", "fourth"} {
+		source <- []byte(msg)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	_, got = poll(t, handler, "/poll?cursor=2")
+	if len(got.Events) != 2 || got.Events[0].Data != "third" || got.Cursor != 4 {
+		t.Errorf("long poll resume want third,fourth got=%+v", got)
+	}
+
+	if status, got := poll(t, handler, "/poll?cursor=1"); status != http.StatusGone || len(got.Events) != 0 || got.Cursor != 4 {
+		t.Errorf("long poll stale cursor want=%d cursor=4 got=%d %+v", http.StatusGone, status, got)
+	}
+
+	start := time.Now()
+	if _, got = poll(t, handler, "/poll?cursor=99"); len(got.Events) != 0 || got.Cursor != 4 || time.Since(start) > 25*time.Millisecond {
+		t.Errorf("long poll cursor ahead want immediate cursor=4 got=%+v after %s", got, time.Since(start))
+	}
+
+	if status, _ := poll(t, handler, "/poll?cursor=abc"); status != http.StatusBadRequest {
+		t.Errorf("long poll invalid cursor want=%d got=%d", http.StatusBadRequest, status)
+	}
+
+	close(source)
+	time.Sleep(10 * time.Millisecond)
+	if status, _ := poll(t, handler, "/poll?cursor=4"); status != http.StatusInternalServerError {
+		t.Errorf("long poll closed source want=%d got=%d", http.StatusInternalServerError, status)
+	}
+}
+
+func TestSSEBufferResume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := make(chan []byte)
+	buf, err := handlers.NewEventBuffer(ctx, func() <-chan []byte { return source }, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"first", "second"} {
+		source <- []byte(msg)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	server := httptest.NewServer(http.HandlerFunc(handlers.ServerSentEventsBuffer(buf, time.Second)))
+	defer server.Close()
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	request.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	for _, want := range []string{"id: 2\n", "data: second\n"} {
+		if line, err := reader.ReadString('\n'); err != nil || line != want {
+			t.Errorf("sse resume want=%q got=%q err=%v", want, line, err)
+		}
+	}
+	for _, msg := range []string{"third", "fourth"} {
+		source <- []byte(msg)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	request.Header.Set("Last-Event-ID", "1")
+	stale, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stale.Body.Close()
+
+	reader = bufio.NewReader(stale.Body)
+	for _, want := range []string{"id: 4\n", "event: reset\n", "data: 4\n"} {
+		if line, err := reader.ReadString('\n'); err != nil || line != want {
+			t.Errorf("sse stale cursor want=%q got=%q err=%v", want, line, err)
+		}
+	}
+}
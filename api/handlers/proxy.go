@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api/helper"
+)
+
+var ErrNoUpstream = fmt.Errorf("no healthy upstream")
+
+// Upstream is a backend of a Pool.
+// It is unhealthy when the active check fails or, passively,
+// for a while after too many consecutive connection failures.
+type Upstream struct {
+	URL       *url.URL
+	healthy   atomic.Bool
+	active    atomic.Int64
+	failures  atomic.Int64
+	downUntil atomic.Int64
+}
+
+func (u *Upstream) Healthy() bool {
+	return u.healthy.Load() && time.Now().UnixNano() >= u.downUntil.Load()
+}
+
+func (u *Upstream) Active() int64 {
+	return u.active.Load()
+}
+
+type Balancer interface {
+	Pick(r *http.Request, upstreams []*Upstream) *Upstream
+}
+
+type RoundRobin struct {
+	n atomic.Uint64
+}
+
+func (b *RoundRobin) Pick(r *http.Request, upstreams []*Upstream) *Upstream {
+	return upstreams[(b.n.Add(1)-1)%uint64(len(upstreams))]
+}
+
+type LeastConnections struct{}
+
+func (b LeastConnections) Pick(r *http.Request, upstreams []*Upstream) *Upstream {
+	best := upstreams[0]
+	for _, u := range upstreams[1:] {
+		if u.Active() < best.Active() {
+			best = u
+		}
+	}
+	return best
+}
+
+// ConsistentHash picks upstreams by rendezvous hashing of the key,
+// so a key keeps its upstream while that upstream stays healthy.
+type ConsistentHash struct {
+	Key func(*http.Request) string
+}
+
+func HashByHeader(name string) ConsistentHash {
+	return ConsistentHash{Key: func(r *http.Request) string { return r.Header.Get(name) }}
+}
+
+// HashByIp keys on the connected peer address. X-Forwarded-For is
+// client supplied and would let callers choose their upstream.
+func HashByIp() ConsistentHash {
+	return ConsistentHash{Key: helper.RemoteIp}
+}
+
+func (b ConsistentHash) Pick(r *http.Request, upstreams []*Upstream) *Upstream {
+	key := b.Key(r)
+
+	var best *Upstream
+	var bestScore uint64
+	for _, u := range upstreams {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte(u.URL.String()))
+		if score := h.Sum64(); best == nil || score > bestScore {
+			best, bestScore = u, score
+		}
+	}
+	return best
+}
+
+type Pool struct {
+	upstreams   []*Upstream
+	balancer    Balancer
+	maxFails    int64
+	failTimeout time.Duration
+}
+
+func NewPool(targets []string, balancer Balancer, opts ...func(*Pool)) (*Pool, error) {
+	p := Pool{balancer: balancer, maxFails: 3, failTimeout: 10 * time.Second}
+	for _, o := range opts {
+		o(&p)
+	}
+
+	for _, target := range targets {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+
+		upstream := &Upstream{URL: u}
+		upstream.healthy.Store(true)
+		p.upstreams = append(p.upstreams, upstream)
+	}
+
+	return &p, nil
+}
+
+// WithPassiveHealth takes an upstream out of rotation for failTimeout
+// after maxFails consecutive connection failures.
+func WithPassiveHealth(maxFails int, failTimeout time.Duration) func(*Pool) {
+	return func(p *Pool) {
+		p.maxFails = int64(maxFails)
+		p.failTimeout = failTimeout
+	}
+}
+
+func (p *Pool) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+// HealthCheck requests path on every upstream each interval until ctx is done.
+// Upstreams answering with a status below 500 are healthy.
+func (p *Pool) HealthCheck(ctx context.Context, client *http.Client, path string, interval time.Duration) {
+	check := func() {
+		var wg sync.WaitGroup
+		for _, u := range p.upstreams {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				target := u.URL.JoinPath(path)
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+				if err != nil {
+					u.healthy.Store(false)
+					return
+				}
+
+				resp, err := client.Do(req)
+				if err != nil {
+					u.healthy.Store(false)
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				u.healthy.Store(resp.StatusCode < http.StatusInternalServerError)
+			}()
+		}
+		wg.Wait()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		check()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pool) next(r *http.Request, tried map[*Upstream]bool) *Upstream {
+	candidates := make([]*Upstream, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		if u.Healthy() && !tried[u] {
+			candidates = append(candidates, u)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+	return p.balancer.Pick(r, candidates)
+}
+
+func (p *Pool) fail(u *Upstream) {
+	if u.failures.Add(1) >= p.maxFails {
+		u.downUntil.Store(time.Now().Add(p.failTimeout).UnixNano())
+		u.failures.Store(0)
+	}
+}
+
+// ProxyConfig configures ReverseProxy.
+// Retries is the number of other upstreams tried after a connection failure.
+// Requests with a body are only retried when it can be replayed through GetBody.
+// SetHeaders and RemoveHeaders rewrite the outbound request headers.
+type ProxyConfig struct {
+	Retries       int
+	SetHeaders    map[string]string
+	RemoveHeaders []string
+	Transport     http.RoundTripper
+	FlushInterval time.Duration
+}
+
+// ReverseProxy forwards requests to the pool.
+// The X-Forwarded-For chain is extended with the client address and the request id
+// and trace context are forwarded. Server-sent event streams are flushed as they arrive.
+func ReverseProxy(pool *Pool, cfg ProxyConfig) func(http.ResponseWriter, *http.Request) {
+	base := cfg.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			if xff := pr.In.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+				pr.Out.Header["X-Forwarded-For"] = xff
+			}
+			pr.SetXForwarded()
+
+			requestId := helper.CtxRequestId(pr.In.Context())
+			if requestId == "" {
+				requestId = pr.In.Header.Get("X-Request-Id")
+			}
+			helper.SetHeaderRequestId(pr.Out.Header, requestId)
+			helper.SetHeaderTrace(pr.Out.Header, helper.CtxTrace(pr.In.Context()))
+
+			for _, name := range cfg.RemoveHeaders {
+				pr.Out.Header.Del(name)
+			}
+			for name, value := range cfg.SetHeaders {
+				pr.Out.Header.Set(name, value)
+			}
+		},
+		Transport:     &poolTransport{pool: pool, base: base, retries: cfg.Retries},
+		FlushInterval: cfg.FlushInterval,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(err, ErrNoUpstream) {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+		},
+	}
+
+	return proxy.ServeHTTP
+}
+
+// poolTransport picks the upstream per attempt so connection failures
+// can be retried on another upstream.
+type poolTransport struct {
+	pool    *Pool
+	base    http.RoundTripper
+	retries int
+}
+
+func (t *poolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A body without GetBody can only be sent once, so it is not retried
+	// rather than being buffered in memory.
+	retries := t.retries
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		retries = 0
+	}
+
+	tried := map[*Upstream]bool{}
+	for attempt := 0; ; attempt++ {
+		u := t.pool.next(req, tried)
+		if u == nil {
+			return nil, ErrNoUpstream
+		}
+		tried[u] = true
+
+		out := req.Clone(req.Context())
+		out.URL.Scheme = u.URL.Scheme
+		out.URL.Host = u.URL.Host
+		out.URL.Path = strings.TrimSuffix(u.URL.Path, "/") + req.URL.Path
+		out.URL.RawPath = ""
+		out.Host = ""
+		if attempt > 0 && req.GetBody != nil {
+			out.Body, _ = req.GetBody()
+		}
+
+		u.active.Add(1)
+		resp, err := t.base.RoundTrip(out)
+		if err != nil {
+			u.active.Add(-1)
+
+			var opErr *net.OpError
+			if !errors.As(err, &opErr) || opErr.Op != "dial" {
+				return nil, err
+			}
+
+			t.pool.fail(u)
+			if attempt >= retries {
+				return nil, err
+			}
+			continue
+		}
+
+		u.failures.Store(0)
+		resp.Body = &releaseBody{ReadCloser: resp.Body, release: func() { u.active.Add(-1) }}
+		return resp, nil
+	}
+}
+
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,135 @@
+package handlers_test
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api/handlers"
+)
+
+func backend(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s %s %s", name, r.URL.Path, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Request-Id"))
+	}))
+}
+
+func proxyGet(t *testing.T, proxy func(http.ResponseWriter, *http.Request), header http.Header) (int, string) {
+	t.Helper()
+
+	request := httptest.NewRequest(http.MethodGet, "/items", nil)
+	for k, v := range header {
+		request.Header[k] = v
+	}
+	recorder := httptest.NewRecorder()
+	proxy(recorder, request)
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestReverseProxyRoundRobin(t *testing.T) {
+	a, b := backend("a"), backend("b")
+	defer a.Close()
+	defer b.Close()
+
+	pool, err := handlers.NewPool([]string{a.URL, b.URL}, &handlers.RoundRobin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := handlers.ReverseProxy(pool, handlers.ProxyConfig{})
+
+	header := http.Header{"X-Forwarded-For": {"203.0.113.7"}, "X-Request-Id": {"antigravity"}}
+	want := []string{
+		"a /items 203.0.113.7, 192.0.2.1 antigravity",
+		"b /items 203.0.113.7, 192.0.2.1 antigravity",
+		"a /items 203.0.113.7, 192.0.2.1 antigravity",
+	}
+
+	for _, w := range want {
+		if _, got := proxyGet(t, proxy, header); got != w {
+			t.Errorf("round robin want=%q got=%q", w, got)
+		}
+	}
+}
+
+func TestReverseProxyConsistentHash(t *testing.T) {
+	a, b := backend("a"), backend("b")
+	defer a.Close()
+	defer b.Close()
+
+	pool, _ := handlers.NewPool([]string{a.URL, b.URL}, handlers.HashByHeader("X-User"))
+	proxy := handlers.ReverseProxy(pool, handlers.ProxyConfig{})
+
+	for _, user := range []string{"jon", "daker", "napoli"} {
+		_, first := proxyGet(t, proxy, http.Header{"X-User": {user}})
+		for range 3 {
+			if _, got := proxyGet(t, proxy, http.Header{"X-User": {user}}); got[0] != first[0] {
+				t.Errorf("consistent hash for %s moved from %c to %c", user, first[0], got[0])
+			}
+		}
+	}
+}
+
+func TestReverseProxyRetry(t *testing.T) {
+	a := backend("a")
+	defer a.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	pool, _ := handlers.NewPool([]string{down.URL, a.URL}, &handlers.RoundRobin{}, handlers.WithPassiveHealth(1, time.Minute))
+	proxy := handlers.ReverseProxy(pool, handlers.ProxyConfig{Retries: 1})
+
+	if status, got := proxyGet(t, proxy, nil); status != http.StatusOK || !strings.HasPrefix(got, "a ") {
+		t.Errorf("retry want a got status=%d body=%q", status, got)
+	}
+
+	if pool.Upstreams()[0].Healthy() {
+		t.Error("failed upstream should be passively marked unhealthy")
+	}
+
+	pool, _ = handlers.NewPool([]string{down.URL, a.URL}, &handlers.RoundRobin{})
+	proxy = handlers.ReverseProxy(pool, handlers.ProxyConfig{Retries: 1})
+	request := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader("once"))
+	recorder := httptest.NewRecorder()
+	proxy(recorder, request)
+	if recorder.Code != http.StatusBadGateway {
+		t.Errorf("body without GetBody should not be retried want=%d got=%d", http.StatusBadGateway, recorder.Code)
+	}
+
+	pool, _ = handlers.NewPool([]string{down.URL}, &handlers.RoundRobin{})
+	proxy = handlers.ReverseProxy(pool, handlers.ProxyConfig{})
+	if status, _ := proxyGet(t, proxy, nil); status != http.StatusBadGateway {
+		t.Errorf("unreachable upstream want=%d got=%d", http.StatusBadGateway, status)
+	}
+}
+
+func TestReverseProxySSE(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	pool, _ := handlers.NewPool([]string{upstream.URL}, &handlers.RoundRobin{})
+	server := httptest.NewServer(http.HandlerFunc(handlers.ReverseProxy(pool, handlers.ProxyConfig{})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "data: first\n" {
+		t.Errorf("sse event want=%q got=%q err=%v", "data: first\n", line, err)
+	}
+}
@@ -1,63 +1,66 @@
 package handlers
 
 import (
-	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 )
 
+// ServerSentEvents streams every message of fn as a data event and a keepalive comment
+// each liveliness. The stream ends when the channel is closed or the client goes away.
 func ServerSentEvents(fn func() <-chan []byte, liveliness time.Duration) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		flusher, ok := w.(http.Flusher)
-		if !ok {
-			http.Error(w, "sse not supported", http.StatusInternalServerError)
-			return
-		}
-
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
-		w.Header().Set("X-Accel-Buffering", "no")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-
-		ctx, cancel := context.WithCancel(r.Context())
-		defer cancel()
-
-		livelinessTicker := time.NewTicker(liveliness)
-		livelinessMsg := []byte(":keepalive\n\n")
+		serveEvents(w, r, liveliness, fn, func(w io.Writer, msg []byte) error {
+			_, err := fmt.Fprintf(w, "data: %s\n\n", msg)
+			return err
+		})
+	}
+}
 
-		message := fn()
+// serveEvents writes the event stream headers and every message of fn with write,
+// fn is only called once the response is known to support flushing.
+func serveEvents[T any](w http.ResponseWriter, r *http.Request, liveliness time.Duration, fn func() <-chan T, write func(io.Writer, T) error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "sse not supported", http.StatusInternalServerError)
+		return
+	}
 
-		for {
-			select {
-			case <-ctx.Done():
-				return
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
 
-			case <-livelinessTicker.C:
-				_, err := w.Write(livelinessMsg)
-				if err != nil {
-					http.Error(w, "err writing message", http.StatusInternalServerError)
-					return
-				}
+	livelinessTicker := time.NewTicker(liveliness)
+	defer livelinessTicker.Stop()
+	livelinessMsg := []byte(":keepalive\n\n")
 
-				flusher.Flush()
+	message := fn()
 
-			case msg, ok := <-message:
+	for {
+		select {
+		case <-r.Context().Done():
+			return
 
-				if !ok {
-					http.Error(w, "err reading message", http.StatusInternalServerError)
-					return
-				}
+		case <-livelinessTicker.C:
+			if _, err := w.Write(livelinessMsg); err != nil {
+				return
+			}
 
-				_, err := fmt.Fprintf(w, "data: %s\n\n", msg)
-				if err != nil {
-					http.Error(w, "err writing message", http.StatusInternalServerError)
-					return
-				}
+		case msg, ok := <-message:
+			if !ok {
+				return
+			}
 
-				flusher.Flush()
+			if err := write(w, msg); err != nil {
+				return
 			}
 		}
+
+		flusher.Flush()
 	}
 }
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultImmutable matches file names carrying a content hash, e.g. app.3f9a2c1d.js.
+var DefaultImmutable = regexp.MustCompile(`[.-][0-9a-fA-F]{8,}\.[a-zA-Z0-9]+$`)
+
+var precompressed = []struct {
+	encoding  string
+	extension string
+}{
+	{encoding: "br", extension: ".br"},
+	{encoding: "gzip", extension: ".gz"},
+}
+
+// StaticConfig configures Static.
+// Prefix is stripped from the request path before the lookup, it matches whole path segments.
+// With SPA set, unknown paths without an extension are served the index.
+// Files matching Immutable are cached for a year, everything else is revalidated.
+type StaticConfig struct {
+	Prefix                string
+	SPA                   bool
+	Index                 string
+	Immutable             *regexp.Regexp
+	ContentSecurityPolicy string
+}
+
+type staticETag struct {
+	tag     string
+	modTime time.Time
+	size    int64
+}
+
+// Static serves files from fsys, e.g. an embed.FS.
+// Precompressed .br and .gz variants next to a file are served when the client accepts them.
+func Static(fsys fs.FS, cfg StaticConfig) func(http.ResponseWriter, *http.Request) {
+	if cfg.Index == "" {
+		cfg.Index = "index.html"
+	}
+	if cfg.Immutable == nil {
+		cfg.Immutable = DefaultImmutable
+	}
+
+	var etags sync.Map
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name, fallback, err := resolve(fsys, cfg, r.URL.Path)
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "SAMEORIGIN")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		if cfg.ContentSecurityPolicy != "" {
+			h.Set("Content-Security-Policy", cfg.ContentSecurityPolicy)
+		}
+
+		served := name
+		h.Add("Vary", "Accept-Encoding")
+		for _, p := range precompressed {
+			if !acceptsEncoding(r, p.encoding) {
+				continue
+			}
+			if info, err := fs.Stat(fsys, name+p.extension); err == nil && !info.IsDir() {
+				served = name + p.extension
+				h.Set("Content-Encoding", p.encoding)
+				break
+			}
+		}
+
+		info, err := fs.Stat(fsys, served)
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		data, err := fs.ReadFile(fsys, served)
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" && served == name {
+			contentType = http.DetectContentType(data)
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h.Set("Content-Type", contentType)
+
+		// Files of an os.DirFS change in place, the tag is computed again when they do.
+		cached, ok := etags.Load(served)
+		if tag, _ := cached.(staticETag); !ok || !tag.modTime.Equal(info.ModTime()) || tag.size != info.Size() {
+			sum := sha256.Sum256(data)
+			cached = staticETag{tag: strconv.Quote(hex.EncodeToString(sum[:16])), modTime: info.ModTime(), size: info.Size()}
+			etags.Store(served, cached)
+		}
+		h.Set("ETag", cached.(staticETag).tag)
+
+		if !fallback && cfg.Immutable.MatchString(path.Base(name)) {
+			h.Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			h.Set("Cache-Control", "no-cache")
+		}
+
+		http.ServeContent(w, r, name, info.ModTime(), bytes.NewReader(data))
+	}
+}
+
+// resolve maps the request path to a file name, reporting whether the SPA index was used as fallback.
+func resolve(fsys fs.FS, cfg StaticConfig, urlPath string) (string, bool, error) {
+	// The prefix matches whole path segments, /static does not match /staticfoo.
+	rest, ok := strings.CutPrefix(urlPath, cfg.Prefix)
+	if !ok || (rest != "" && rest[0] != '/' && !strings.HasSuffix(cfg.Prefix, "/")) {
+		return "", false, fs.ErrNotExist
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+rest), "/")
+	if name == "" {
+		name = cfg.Index
+	}
+
+	info, err := fs.Stat(fsys, name)
+	if err == nil && info.IsDir() {
+		name = path.Join(name, cfg.Index)
+		info, err = fs.Stat(fsys, name)
+	}
+
+	if err == nil && !info.IsDir() {
+		return name, false, nil
+	}
+
+	if errors.Is(err, fs.ErrNotExist) && cfg.SPA && path.Ext(name) == "" {
+		if _, err := fs.Stat(fsys, cfg.Index); err == nil {
+			return cfg.Index, true, nil
+		}
+	}
+
+	return "", false, fs.ErrNotExist
+}
+
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for part := range strings.SplitSeq(v, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+				continue
+			}
+
+			q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+			if !found {
+				return true
+			}
+
+			weight, err := strconv.ParseFloat(q, 64)
+			return err == nil && weight > 0
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api/handlers"
+)
+
+var assets = fstest.MapFS{
+	"index.html":                {Data: []byte("<html>spa</html>")},
+	"assets/app.3f9a2c1d.js":    {Data: []byte("console.log('app')")},
+	"assets/app.3f9a2c1d.js.br": {Data: []byte("brotli")},
+	"assets/app.3f9a2c1d.js.gz": {Data: []byte("gzip")},
+	"assets/style.css":          {Data: []byte("body{}")},
+}
+
+func TestStatic(t *testing.T) {
+	static := handlers.Static(assets, handlers.StaticConfig{Prefix: "/admin", SPA: true})
+
+	tests := []struct {
+		path           string
+		acceptEncoding string
+		wantStatus     int
+		wantBody       string
+		wantType       string
+		wantEncoding   string
+		wantCache      string
+	}{
+		{path: "/admin/", wantStatus: 200, wantBody: "<html>spa</html>", wantType: "text/html; charset=utf-8", wantCache: "no-cache"},
+		{path: "/admin/users/42", wantStatus: 200, wantBody: "<html>spa</html>", wantType: "text/html; charset=utf-8", wantCache: "no-cache"},
+		{path: "/admin/assets/missing.js", wantStatus: 404},
+		{path: "/administrator/users", wantStatus: 404},
+		{path: "/admin/assets/style.css", wantStatus: 200, wantBody: "body{}", wantType: "text/css; charset=utf-8", wantCache: "no-cache"},
+		{path: "/admin/assets/app.3f9a2c1d.js", wantStatus: 200, wantBody: "console.log('app')", wantType: "text/javascript; charset=utf-8", wantCache: "public, max-age=31536000, immutable"},
+		{path: "/admin/assets/app.3f9a2c1d.js", acceptEncoding: "gzip, br", wantStatus: 200, wantBody: "brotli", wantType: "text/javascript; charset=utf-8", wantEncoding: "br", wantCache: "public, max-age=31536000, immutable"},
+		{path: "/admin/assets/app.3f9a2c1d.js", acceptEncoding: "gzip, br;q=0", wantStatus: 200, wantBody: "gzip", wantType: "text/javascript; charset=utf-8", wantEncoding: "gzip", wantCache: "public, max-age=31536000, immutable"},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, test.path, nil)
+		request.Header.Set("Accept-Encoding", test.acceptEncoding)
+		recorder := httptest.NewRecorder()
+		static(recorder, request)
+
+		result := recorder.Result()
+		if result.StatusCode != test.wantStatus {
+			t.Errorf("static %s status want=%d got=%d", test.path, test.wantStatus, result.StatusCode)
+			continue
+		}
+		if test.wantStatus != http.StatusOK {
+			continue
+		}
+
+		if got := recorder.Body.String(); got != test.wantBody {
+			t.Errorf("static %s body want=%q got=%q", test.path, test.wantBody, got)
+		}
+		if got := result.Header.Get("Content-Type"); got != test.wantType {
+			t.Errorf("static %s content type want=%q got=%q", test.path, test.wantType, got)
+		}
+		if got := result.Header.Get("Content-Encoding"); got != test.wantEncoding {
+			t.Errorf("static %s encoding want=%q got=%q", test.path, test.wantEncoding, got)
+		}
+		if got := result.Header.Get("Cache-Control"); got != test.wantCache {
+			t.Errorf("static %s cache control want=%q got=%q", test.path, test.wantCache, got)
+		}
+		if result.Header.Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("static %s security headers missing", test.path)
+		}
+	}
+}
+
+func TestStaticETag(t *testing.T) {
+	static := handlers.Static(assets, handlers.StaticConfig{})
+
+	recorder := httptest.NewRecorder()
+	static(recorder, httptest.NewRequest(http.MethodGet, "/assets/style.css", nil))
+	tag := recorder.Header().Get("ETag")
+
+	request := httptest.NewRequest(http.MethodGet, "/assets/style.css", nil)
+	request.Header.Set("If-None-Match", tag)
+	recorder = httptest.NewRecorder()
+	static(recorder, request)
+
+	if tag == "" || recorder.Code != http.StatusNotModified {
+		t.Errorf("static etag want=%d got=%d etag=%q", http.StatusNotModified, recorder.Code, tag)
+	}
+}
+
+func TestStaticETagChange(t *testing.T) {
+	files := fstest.MapFS{"style.css": {Data: []byte("body{}"), ModTime: time.Unix(1, 0)}}
+	static := handlers.Static(files, handlers.StaticConfig{})
+
+	recorder := httptest.NewRecorder()
+	static(recorder, httptest.NewRequest(http.MethodGet, "/style.css", nil))
+	before := recorder.Header().Get("ETag")
+
+	files["style.css"] = &fstest.MapFile{Data: []byte("body{color:red}"), ModTime: time.Unix(2, 0)}
+	recorder = httptest.NewRecorder()
+	static(recorder, httptest.NewRequest(http.MethodGet, "/style.css", nil))
+
+	if after := recorder.Header().Get("ETag"); after == before || recorder.Body.String() != "body{color:red}" {
+		t.Errorf("changed file etag should change, before=%s after=%s body=%q", before, after, recorder.Body.String())
+	}
+}
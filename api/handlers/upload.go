@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrPartTooLarge   = fmt.Errorf("upload part too large")
+	ErrUploadTooLarge = fmt.Errorf("upload too large")
+	ErrContentType    = fmt.Errorf("upload content type not allowed")
+)
+
+// maxFieldsSize caps the form fields kept in memory, like http.Request.ParseMultipartForm.
+const maxFieldsSize = 10 << 20
+
+// UploadPart describes a received multipart part.
+// File parts are spooled to Path unless a sink is configured, form fields are kept in Value.
+type UploadPart struct {
+	Field       string
+	FileName    string
+	ContentType string
+	Size        int64
+	Sha256      string
+	Path        string
+	Value       string
+}
+
+// UploadAborter is implemented by sink writers that can discard a part,
+// e.g. a multipart upload to object storage.
+// Abort is called instead of Close when the part fails or the client aborts.
+type UploadAborter interface {
+	Abort(err error) error
+}
+
+// UploadConfig limits and routes uploads.
+// Zero limits are unlimited, except form fields which are capped at 10MB in total.
+// AllowedTypes match the sniffed content type,
+// either exactly or by a "type/*" wildcard, and allow everything when empty.
+// Sink writers are closed once their part is received, see UploadAborter.
+type UploadConfig struct {
+	MaxPartSize  int64
+	MaxTotalSize int64
+	AllowedTypes []string
+	Dir          string
+	Sink         func(part *multipart.Part) (io.Writer, error)
+	Progress     func(field string, written int64)
+}
+
+// Upload streams a multipart/form-data body part by part.
+// Spooled files are removed once done returns, done must move any file it wants to keep.
+// When the client aborts or a limit is exceeded everything received so far is removed.
+// The route should be excluded from body buffering with api.WithStreamingBody.
+func Upload(cfg UploadConfig, done func(w http.ResponseWriter, r *http.Request, parts []UploadPart)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if cfg.MaxTotalSize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxTotalSize)
+		}
+
+		mr, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var parts []UploadPart
+		fields := int64(maxFieldsSize)
+		defer func() {
+			for _, p := range parts {
+				if p.Path != "" {
+					os.Remove(p.Path)
+				}
+			}
+		}()
+
+		for {
+			part, err := mr.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				uploadError(w, err)
+				return
+			}
+
+			p, err := receivePart(cfg, part, fields)
+			part.Close()
+			if p.FileName == "" {
+				fields -= p.Size
+			}
+			if p.Path != "" || err == nil {
+				parts = append(parts, p)
+			}
+			if err != nil {
+				uploadError(w, err)
+				return
+			}
+		}
+
+		done(w, r, parts)
+	}
+}
+
+func receivePart(cfg UploadConfig, part *multipart.Part, fields int64) (p UploadPart, err error) {
+	p = UploadPart{Field: part.FormName(), FileName: part.FileName()}
+
+	reader := bufio.NewReaderSize(part, 512)
+	head, err := reader.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return p, err
+	}
+	p.ContentType = http.DetectContentType(head)
+
+	if p.FileName == "" {
+		limit := fields
+		if cfg.MaxPartSize > 0 {
+			limit = min(limit, cfg.MaxPartSize)
+		}
+
+		var value strings.Builder
+		n, err := io.Copy(&value, io.LimitReader(reader, limit+1))
+		if err == nil && n > limit {
+			err = ErrPartTooLarge
+		}
+		p.Size, p.Value = n, value.String()
+		return p, err
+	}
+
+	if !contentTypeAllowed(cfg.AllowedTypes, p.ContentType) {
+		return p, ErrContentType
+	}
+
+	var dst io.Writer
+	if cfg.Sink != nil {
+		dst, err = cfg.Sink(part)
+		if err != nil {
+			return p, err
+		}
+		defer func() {
+			if a, ok := dst.(UploadAborter); ok && err != nil {
+				a.Abort(err)
+			} else if c, ok := dst.(io.Closer); ok {
+				c.Close()
+			}
+		}()
+	} else {
+		f, err := os.CreateTemp(cfg.Dir, "upload-*")
+		if err != nil {
+			return p, err
+		}
+		defer f.Close()
+		dst, p.Path = f, f.Name()
+	}
+
+	hash := sha256.New()
+	writers := []io.Writer{dst, hash}
+	if cfg.Progress != nil {
+		writers = append(writers, &progress{field: p.Field, fn: cfg.Progress})
+	}
+
+	p.Size, err = copyLimited(io.MultiWriter(writers...), reader, cfg.MaxPartSize)
+	p.Sha256 = hex.EncodeToString(hash.Sum(nil))
+	return p, err
+}
+
+func copyLimited(dst io.Writer, src io.Reader, limit int64) (int64, error) {
+	if limit <= 0 {
+		return io.Copy(dst, src)
+	}
+
+	n, err := io.Copy(dst, io.LimitReader(src, limit+1))
+	if err == nil && n > limit {
+		return n, ErrPartTooLarge
+	}
+	return n, err
+}
+
+func contentTypeAllowed(allowed []string, contentType string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	major, _, _ := strings.Cut(mediaType, "/")
+	return slices.Contains(allowed, mediaType) || slices.Contains(allowed, major+"/*")
+}
+
+func uploadError(w http.ResponseWriter, err error) {
+	var maxBytes *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &maxBytes):
+		http.Error(w, ErrUploadTooLarge.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrPartTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrContentType):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+type progress struct {
+	field   string
+	written int64
+	fn      func(string, int64)
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	p.fn(p.field, p.written)
+	return len(b), nil
+}
@@ -0,0 +1,175 @@
+package handlers_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bhmt/tittlemanscrest/api/handlers"
+)
+
+var png = append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), bytes.Repeat([]byte{0}, 64)...)
+
+func multipartRequest(t *testing.T, field, name string, content []byte) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("title", "antigravity")
+
+	fw, err := mw.CreateFormFile(field, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(content)
+	mw.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	request.Header.Set("Content-Type", mw.FormDataContentType())
+	return request
+}
+
+func TestUpload(t *testing.T) {
+	var got []handlers.UploadPart
+	var spooled []string
+	var progress int64
+
+	upload := handlers.Upload(
+		handlers.UploadConfig{
+			MaxPartSize:  1024,
+			AllowedTypes: []string{"image/*"},
+			Dir:          t.TempDir(),
+			Progress:     func(field string, written int64) { progress = written },
+		},
+		func(w http.ResponseWriter, r *http.Request, parts []handlers.UploadPart) {
+			got = parts
+			for _, p := range parts {
+				if p.Path == "" {
+					continue
+				}
+				if _, err := os.Stat(p.Path); err != nil {
+					t.Error(err)
+				}
+				spooled = append(spooled, p.Path)
+			}
+			w.WriteHeader(http.StatusCreated)
+		},
+	)
+
+	recorder := httptest.NewRecorder()
+	upload(recorder, multipartRequest(t, "image", "image.png", png))
+
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("upload status want=%d got=%d body=%s", http.StatusCreated, recorder.Code, recorder.Body.String())
+	}
+
+	sum := sha256.Sum256(png)
+	if len(got) != 2 || got[0].Value != "antigravity" || got[1].ContentType != "image/png" || got[1].Sha256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("upload parts mismatch, got=%+v", got)
+	}
+
+	if progress != int64(len(png)) {
+		t.Errorf("upload progress want=%d got=%d", len(png), progress)
+	}
+
+	for _, path := range spooled {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("spooled file %s not removed", path)
+		}
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	dir := t.TempDir()
+	upload := handlers.Upload(
+		handlers.UploadConfig{MaxPartSize: 32, AllowedTypes: []string{"image/png"}, Dir: dir},
+		func(w http.ResponseWriter, r *http.Request, parts []handlers.UploadPart) {
+			t.Error("upload should have been rejected")
+		},
+	)
+
+	tests := []struct {
+		content []byte
+		want    int
+	}{
+		{content: png, want: http.StatusRequestEntityTooLarge},
+		{content: []byte("plain text"), want: http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		upload(recorder, multipartRequest(t, "file", "file", test.content))
+
+		if recorder.Code != test.want {
+			t.Errorf("upload limit status want=%d got=%d", test.want, recorder.Code)
+		}
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("rejected uploads left %d files", len(entries))
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, field := range []string{"a", "b"} {
+		mw.WriteField(field, strings.Repeat("x", 6<<20))
+	}
+	mw.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	request.Header.Set("Content-Type", mw.FormDataContentType())
+	recorder := httptest.NewRecorder()
+	handlers.Upload(handlers.UploadConfig{Dir: dir}, func(w http.ResponseWriter, r *http.Request, parts []handlers.UploadPart) {
+		t.Error("oversized form fields should have been rejected")
+	})(recorder, request)
+
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("form fields status want=%d got=%d", http.StatusRequestEntityTooLarge, recorder.Code)
+	}
+}
+
+type abortingSink struct {
+	bytes.Buffer
+	closed  bool
+	aborted error
+}
+
+func (s *abortingSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func (s *abortingSink) Abort(err error) error {
+	s.aborted = err
+	return nil
+}
+
+func TestUploadSinkAbort(t *testing.T) {
+	var sink *abortingSink
+	upload := handlers.Upload(
+		handlers.UploadConfig{MaxPartSize: 32, Sink: func(part *multipart.Part) (io.Writer, error) {
+			sink = &abortingSink{}
+			return sink, nil
+		}},
+		func(w http.ResponseWriter, r *http.Request, parts []handlers.UploadPart) {},
+	)
+
+	upload(httptest.NewRecorder(), multipartRequest(t, "file", "small.png", png[:16]))
+	if sink == nil || !sink.closed || sink.aborted != nil {
+		t.Errorf("received part should be closed got=%+v", sink)
+	}
+
+	recorder := httptest.NewRecorder()
+	upload(recorder, multipartRequest(t, "file", "large.png", png))
+	if recorder.Code != http.StatusRequestEntityTooLarge || sink.closed || !errors.Is(sink.aborted, handlers.ErrPartTooLarge) {
+		t.Errorf("failed part should be aborted got=%d closed=%t aborted=%v", recorder.Code, sink.closed, sink.aborted)
+	}
+}
@@ -3,6 +3,8 @@ package helper
 import (
 	"net"
 	"net/http"
+	"net/netip"
+	"strings"
 )
 
 func Ip(r *http.Request) string {
@@ -16,3 +18,44 @@ func Ip(r *http.Request) string {
 
 	return ""
 }
+
+// RemoteIp returns the address of the connected peer, ignoring
+// client supplied headers such as X-Forwarded-For.
+func RemoteIp(r *http.Request) string {
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return ip
+	}
+
+	return r.RemoteAddr
+}
+
+// ForwardedIp walks X-Forwarded-For from the right while the hop is one of
+// the trusted proxies and returns the first untrusted address.
+// Without a trusted peer it returns RemoteIp.
+func ForwardedIp(r *http.Request, trusted []netip.Prefix) string {
+	ip := RemoteIp(r)
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0 && isTrusted(ip, trusted); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+	}
+
+	return ip
+}
+
+func isTrusted(ip string, trusted []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package helper
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/google/uuid"
@@ -8,14 +9,26 @@ import (
 
 var requestIdHeader = "X-Request-Id"
 
-func GetCtxRequestId(r *http.Request) string {
-	if id, ok := r.Context().Value(requestIdHeader).(string); ok {
+type requestIdContextKeyType struct{}
+
+var requestIdContextKey = requestIdContextKeyType{}
+
+func SetCtxRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdContextKey, id)
+}
+
+func CtxRequestId(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIdContextKey).(string); ok {
 		return id
 	}
 
 	return ""
 }
 
+func GetCtxRequestId(r *http.Request) string {
+	return CtxRequestId(r.Context())
+}
+
 func GetHeaderRequestId(r *http.Request) string {
 	if id := r.Header.Get(requestIdHeader); id != "" {
 		return id
@@ -24,3 +37,9 @@ func GetHeaderRequestId(r *http.Request) string {
 	v7, _ := uuid.NewV7()
 	return v7.String()
 }
+
+func SetHeaderRequestId(h http.Header, id string) {
+	if id != "" && h.Get(requestIdHeader) == "" {
+		h.Set(requestIdHeader, id)
+	}
+}
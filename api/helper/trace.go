@@ -0,0 +1,55 @@
+package helper
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/bhmt/tittlemanscrest/logging"
+)
+
+var (
+	traceparentHeader = "Traceparent"
+	tracestateHeader  = "Tracestate"
+)
+
+// Trace holds the W3C trace context headers of a request.
+type Trace struct {
+	Parent string
+	State  string
+}
+
+type traceContextKeyType struct{}
+
+var traceContextKey = traceContextKeyType{}
+
+func GetHeaderTrace(r *http.Request) Trace {
+	return Trace{
+		Parent: r.Header.Get(traceparentHeader),
+		State:  r.Header.Get(tracestateHeader),
+	}
+}
+
+func SetHeaderTrace(h http.Header, t Trace) {
+	if t.Parent == "" || h.Get(traceparentHeader) != "" {
+		return
+	}
+
+	h.Set(traceparentHeader, t.Parent)
+	if t.State != "" {
+		h.Set(tracestateHeader, t.State)
+	}
+}
+
+func SetCtxTrace(ctx context.Context, t Trace) context.Context {
+	return context.WithValue(ctx, traceContextKey, t)
+}
+
+func CtxTrace(ctx context.Context) Trace {
+	t, _ := ctx.Value(traceContextKey).(Trace)
+	return t
+}
+
+// TraceId returns the trace-id field of the traceparent, see logging.TraceId.
+func (t Trace) TraceId() string {
+	return logging.TraceId(t.Parent)
+}
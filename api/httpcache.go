@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/cache"
+)
+
+// CachedResponse is a response stored by MiddlewareCache.
+// An entry with a non empty Vary only records which request headers select the variant.
+type CachedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Vary       []string
+	Stored     time.Time
+	Expires    time.Time
+	Stale      time.Duration
+}
+
+// MiddlewareCache serves GET and HEAD responses from the store while they are fresh.
+// Only 200 responses with a max-age are stored, no-store and private responses are skipped
+// as are responses setting cookies, which belong to a single client.
+// Responses to requests with Authorization are stored only when marked public, s-maxage or must-revalidate.
+// Within the stale-while-revalidate window the stale response is served
+// and refreshed in the background.
+func MiddlewareCache(store *cache.LRU[string, CachedResponse], next http.Handler) http.Handler {
+	var revalidating sync.Map
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		directives := parseCacheControl(r.Header.Get("Cache-Control"))
+		if _, ok := directives["no-store"]; ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if _, ok := directives["no-cache"]; !ok {
+			if key, entry, ok := cacheLookup(store, r); ok {
+				now := time.Now()
+
+				if now.Before(entry.Expires) {
+					serveCached(w, r, entry, now)
+					return
+				}
+
+				if now.Before(entry.Expires.Add(entry.Stale)) {
+					serveCached(w, r, entry, now)
+
+					if _, loaded := revalidating.LoadOrStore(key, struct{}{}); !loaded {
+						rr := r.Clone(context.WithoutCancel(r.Context()))
+						go func() {
+							defer revalidating.Delete(key)
+							i := newBufferedIntercept(&discardWriter{header: http.Header{}})
+							next.ServeHTTP(i, rr)
+							cacheStore(store, rr, i)
+						}()
+					}
+					return
+				}
+			}
+		}
+
+		i := newBufferedIntercept(w)
+		next.ServeHTTP(i, r)
+		cacheStore(store, r, i)
+		i.writeTo(w)
+	})
+}
+
+func cacheKey(r *http.Request, vary []string) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteString(" ")
+	b.WriteString(r.Host)
+	b.WriteString(r.URL.RequestURI())
+
+	for _, name := range vary {
+		b.WriteString("\n")
+		b.WriteString(name)
+		b.WriteString(":")
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+
+	return b.String()
+}
+
+func cacheLookup(store *cache.LRU[string, CachedResponse], r *http.Request) (string, *CachedResponse, bool) {
+	key := cacheKey(r, nil)
+	entry, ok := store.Get(key)
+	if !ok {
+		return key, nil, false
+	}
+
+	if len(entry.Vary) == 0 {
+		return key, entry, true
+	}
+
+	key = cacheKey(r, entry.Vary)
+	entry, ok = store.Get(key)
+	return key, entry, ok
+}
+
+func cacheStore(store *cache.LRU[string, CachedResponse], r *http.Request, i *intercept) {
+	if i.StatusCode != http.StatusOK {
+		return
+	}
+
+	header := i.Header().Clone()
+	directives := parseCacheControl(header.Get("Cache-Control"))
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[d]; ok {
+			return
+		}
+	}
+
+	// A cookie set for one client must not be replayed to others, RFC 9111 3.
+	if len(header.Values("Set-Cookie")) > 0 {
+		return
+	}
+
+	// A shared cache only stores authenticated responses the origin allows explicitly, RFC 9111 3.5.
+	if r.Header.Get("Authorization") != "" && !hasAny(directives, "public", "s-maxage", "must-revalidate") {
+		return
+	}
+
+	maxAge, ok := directives["s-maxage"]
+	if !ok {
+		maxAge, ok = directives["max-age"]
+	}
+	if !ok {
+		return
+	}
+
+	age, err := strconv.Atoi(maxAge)
+	if err != nil || age <= 0 {
+		return
+	}
+
+	var stale int
+	if v, ok := directives["stale-while-revalidate"]; ok {
+		stale, _ = strconv.Atoi(v)
+	}
+
+	var vary []string
+	for _, v := range header.Values("Vary") {
+		for name := range strings.SplitSeq(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return
+			}
+			if name != "" {
+				vary = append(vary, name)
+			}
+		}
+	}
+
+	now := time.Now()
+	entry := CachedResponse{
+		StatusCode: i.StatusCode,
+		Header:     header,
+		Body:       append([]byte(nil), i.Body.Bytes()...),
+		Stored:     now,
+		Expires:    now.Add(time.Duration(age) * time.Second),
+		Stale:      time.Duration(stale) * time.Second,
+	}
+
+	if len(vary) == 0 {
+		store.Add(cacheKey(r, nil), entry)
+		return
+	}
+
+	store.Add(cacheKey(r, nil), CachedResponse{Vary: vary})
+	store.Add(cacheKey(r, vary), entry)
+}
+
+func serveCached(w http.ResponseWriter, r *http.Request, entry *CachedResponse, now time.Time) {
+	h := w.Header()
+	for k, v := range entry.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set("Age", strconv.Itoa(int(now.Sub(entry.Stored).Seconds())))
+
+	if notModified(r, h) {
+		writeNotModified(w)
+		return
+	}
+
+	w.WriteHeader(entry.StatusCode)
+	if r.Method != http.MethodHead {
+		w.Write(entry.Body)
+	}
+}
+
+func hasAny(directives map[string]string, names ...string) bool {
+	for _, name := range names {
+		if _, ok := directives[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCacheControl(header string) map[string]string {
+	directives := map[string]string{}
+	for part := range strings.SplitSeq(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, value, _ := strings.Cut(part, "=")
+		directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+
+	return directives
+}
+
+// discardWriter backs background revalidation where there is no client to write to.
+type discardWriter struct {
+	header http.Header
+}
+
+func (d *discardWriter) Header() http.Header         { return d.header }
+func (d *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (d *discardWriter) WriteHeader(int)             {}
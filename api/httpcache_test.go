@@ -0,0 +1,176 @@
+package api_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api"
+	"github.com/bhmt/tittlemanscrest/cache"
+)
+
+func TestETag(t *testing.T) {
+	handler := api.MiddlewareETag(false, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	tag := recorder.Result().Header.Get("ETag")
+	if recorder.Code != http.StatusOK || tag == "" {
+		t.Fatalf("etag missing, status=%d etag=%q", recorder.Code, tag)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("If-None-Match", "W/"+tag)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+		t.Errorf("etag conditional mismatch, want=%d got=%d body=%q", http.StatusNotModified, recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodHead, "/", nil))
+	if got := recorder.Result().Header.Get("ETag"); got != tag || recorder.Body.Len() != 0 {
+		t.Errorf("head etag want=%s got=%s body=%q", tag, got, recorder.Body.String())
+	}
+
+	request = httptest.NewRequest(http.MethodHead, "/", nil)
+	request.Header.Set("If-None-Match", tag)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusNotModified {
+		t.Errorf("head conditional want=%d got=%d", http.StatusNotModified, recorder.Code)
+	}
+}
+
+func TestCache(t *testing.T) {
+	store, _ := cache.New[string, api.CachedResponse](16, 0)
+
+	calls := 0
+	handler := api.MiddlewareCache(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprintf(w, "%s %d", r.Header.Get("Accept-Language"), calls)
+	}))
+
+	tests := []struct {
+		language string
+		want     string
+	}{
+		{language: "en", want: "en 1"},
+		{language: "en", want: "en 1"},
+		{language: "de", want: "de 2"},
+		{language: "de", want: "de 2"},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/cached", nil)
+		request.Header.Set("Accept-Language", test.language)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		if got := recorder.Body.String(); got != test.want {
+			t.Errorf("cache body mismatch, want=%q got=%q", test.want, got)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/cached", nil)
+	request.Header.Set("Accept-Language", "en")
+	request.Header.Set("Cache-Control", "no-store")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if got := recorder.Body.String(); got != "en 3" {
+		t.Errorf("cache no-store mismatch, want=%q got=%q", "en 3", got)
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	store, _ := cache.New[string, api.CachedResponse](16, 0)
+
+	calls := make(chan struct{}, 2)
+	handler := api.MiddlewareCache(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls <- struct{}{}
+		w.Header().Set("Cache-Control", "max-age=1, stale-while-revalidate=60")
+		w.Write([]byte("ok"))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/stale", nil))
+	<-calls
+
+	time.Sleep(1100 * time.Millisecond)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/stale", nil))
+	if recorder.Body.String() != "ok" {
+		t.Errorf("stale body mismatch, got=%q", recorder.Body.String())
+	}
+
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Error("stale response was not revalidated")
+	}
+}
+
+func TestCacheAuthorization(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		shared       bool
+	}{
+		{cacheControl: "max-age=60", shared: false},
+		{cacheControl: "public, max-age=60", shared: true},
+		{cacheControl: "s-maxage=60", shared: true},
+	}
+
+	for _, test := range tests {
+		store, _ := cache.New[string, api.CachedResponse](16, 0)
+		handler := api.MiddlewareCache(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", test.cacheControl)
+			fmt.Fprint(w, r.Header.Get("Authorization"))
+		}))
+
+		var bodies []string
+		for _, credentials := range []string{"Bearer alice", "Bearer bob"} {
+			request := httptest.NewRequest(http.MethodGet, "/account", nil)
+			request.Header.Set("Authorization", credentials)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			bodies = append(bodies, recorder.Body.String())
+		}
+
+		if shared := bodies[1] == "Bearer alice"; shared != test.shared {
+			t.Errorf("%q shared want=%v got bodies=%q", test.cacheControl, test.shared, bodies)
+		}
+	}
+}
+
+func TestCacheSetCookie(t *testing.T) {
+	store, _ := cache.New[string, api.CachedResponse](16, 0)
+
+	var calls int
+	handler := api.MiddlewareCache(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: strconv.Itoa(calls)})
+		fmt.Fprint(w, "ok")
+	}))
+
+	var cookies []string
+	for range 2 {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/login", nil))
+		cookies = append(cookies, recorder.Header().Get("Set-Cookie"))
+	}
+
+	if calls != 2 || cookies[0] == cookies[1] {
+		t.Errorf("responses setting cookies should not be cached, calls=%d cookies=%q", calls, cookies)
+	}
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"bufio"
+	"bytes"
 	"net"
 	"net/http"
 )
@@ -9,33 +10,72 @@ import (
 type intercept struct {
 	http.ResponseWriter
 	StatusCode int
+	Bytes      int
+	Body       *bytes.Buffer
+	Tee        *bytes.Buffer
+	teeLimit   int
 }
 
 func newIntercept(w http.ResponseWriter) *intercept {
 	return &intercept{ResponseWriter: w, StatusCode: 200}
 }
 
-func (i intercept) Header() http.Header {
+// newTeeIntercept passes writes through and keeps a copy of up to limit bytes of the body.
+func newTeeIntercept(w http.ResponseWriter, limit int) *intercept {
+	return &intercept{ResponseWriter: w, StatusCode: 200, Tee: &bytes.Buffer{}, teeLimit: limit}
+}
+
+// newBufferedIntercept holds the status and body back from the underlying writer.
+// The caller is responsible for writing the captured response with writeTo.
+func newBufferedIntercept(w http.ResponseWriter) *intercept {
+	return &intercept{ResponseWriter: w, StatusCode: 200, Body: &bytes.Buffer{}}
+}
+
+func (i *intercept) Header() http.Header {
 	return i.ResponseWriter.Header()
 }
 
-func (i intercept) Write(data []byte) (int, error) {
-	return i.ResponseWriter.Write(data)
+func (i *intercept) Write(data []byte) (int, error) {
+	var n int
+	var err error
+	if i.Body != nil {
+		n, err = i.Body.Write(data)
+	} else {
+		n, err = i.ResponseWriter.Write(data)
+	}
+	if i.Tee != nil && i.Tee.Len() < i.teeLimit {
+		i.Tee.Write(data[:min(n, i.teeLimit-i.Tee.Len())])
+	}
+	i.Bytes += n
+	return n, err
 }
 
 func (i *intercept) WriteHeader(statusCode int) {
 	i.StatusCode = statusCode
+	if i.Body != nil {
+		return
+	}
 	i.ResponseWriter.WriteHeader(statusCode)
 }
 
 func (i *intercept) Flush() {
+	if i.Body != nil {
+		return
+	}
 	if f, ok := i.ResponseWriter.(http.Flusher); ok {
 		f.Flush()
 	}
 }
+
 func (i *intercept) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	if h, ok := i.ResponseWriter.(http.Hijacker); ok {
-		h.Hijack()
+	h, ok := i.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
 	}
-	return nil, nil, nil
+	return h.Hijack()
+}
+
+func (i *intercept) writeTo(w http.ResponseWriter) {
+	w.WriteHeader(i.StatusCode)
+	w.Write(i.Body.Bytes())
 }
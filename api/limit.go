@@ -0,0 +1,232 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrLimitExceeded = fmt.Errorf("concurrency limit exceeded")
+	ErrLimitQueue    = fmt.Errorf("concurrency limit queue full")
+	ErrLimitTimeout  = fmt.Errorf("concurrency limit queue timeout")
+	ErrLimitInvalid  = fmt.Errorf("invalid concurrency limit")
+)
+
+// Priority classifies requests for admission control.
+// Critical requests bypass the limiter, low priority requests are shed first.
+type Priority int
+
+const (
+	PriorityLow      Priority = -1
+	PriorityNormal   Priority = 0
+	PriorityCritical Priority = 1
+)
+
+type LimiterStats struct {
+	Limit    int
+	InFlight int
+	Rejected uint64
+}
+
+// Limiter admits requests up to its limit.
+// The release function must be called once the request is done,
+// failed reports whether the request should count against the limit.
+type Limiter interface {
+	Acquire(ctx context.Context, p Priority) (release func(failed bool), err error)
+	Stats() LimiterStats
+}
+
+// FixedLimiter admits up to limit requests at once.
+// Up to queue requests wait for a free slot for at most timeout.
+// Low priority requests are never queued.
+type FixedLimiter struct {
+	slots    chan struct{}
+	queue    chan struct{}
+	timeout  time.Duration
+	rejected atomic.Uint64
+}
+
+func NewFixedLimiter(limit int, queue int, timeout time.Duration) *FixedLimiter {
+	return &FixedLimiter{
+		slots:   make(chan struct{}, limit),
+		queue:   make(chan struct{}, queue),
+		timeout: timeout,
+	}
+}
+
+func (l *FixedLimiter) Acquire(ctx context.Context, p Priority) (func(bool), error) {
+	release := func(bool) { <-l.slots }
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if p <= PriorityLow {
+		l.rejected.Add(1)
+		return nil, ErrLimitExceeded
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+		defer func() { <-l.queue }()
+	default:
+		l.rejected.Add(1)
+		return nil, ErrLimitQueue
+	}
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		l.rejected.Add(1)
+		return nil, ErrLimitTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *FixedLimiter) Stats() LimiterStats {
+	return LimiterStats{
+		Limit:    cap(l.slots),
+		InFlight: len(l.slots),
+		Rejected: l.rejected.Load(),
+	}
+}
+
+// AdaptiveLimiter adjusts its limit with additive increase and multiplicative decrease.
+// Requests slower than the target latency or failing shrink the limit by the backoff factor,
+// other requests grow it by one per limit's worth of completed requests.
+// Low priority requests are rejected once the limiter is 80% utilized.
+type AdaptiveLimiter struct {
+	mu       sync.Mutex
+	limit    float64
+	min      float64
+	max      float64
+	inflight int
+	target   time.Duration
+	backoff  float64
+	rejected atomic.Uint64
+}
+
+// NewAdaptiveLimiter requires 1 <= min <= initial <= max.
+func NewAdaptiveLimiter(initial, min, max int, target time.Duration) (*AdaptiveLimiter, error) {
+	if min < 1 || initial < min || max < initial {
+		return nil, fmt.Errorf("%w: want 1 <= min <= initial <= max got min=%d initial=%d max=%d", ErrLimitInvalid, min, initial, max)
+	}
+
+	return &AdaptiveLimiter{
+		limit:   float64(initial),
+		min:     float64(min),
+		max:     float64(max),
+		target:  target,
+		backoff: 0.9,
+	}, nil
+}
+
+func (l *AdaptiveLimiter) Acquire(ctx context.Context, p Priority) (func(bool), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit := int(l.limit)
+	if p <= PriorityLow {
+		limit = int(l.limit * 0.8)
+	}
+
+	if l.inflight >= limit {
+		l.rejected.Add(1)
+		return nil, ErrLimitExceeded
+	}
+
+	l.inflight++
+	start := time.Now()
+
+	return func(failed bool) {
+		rtt := time.Since(start)
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.inflight--
+		if failed || rtt > l.target {
+			l.limit = max(l.min, l.limit*l.backoff)
+			return
+		}
+
+		l.limit = min(l.max, l.limit+1/l.limit)
+	}, nil
+}
+
+func (l *AdaptiveLimiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return LimiterStats{
+		Limit:    int(l.limit),
+		InFlight: l.inflight,
+		Rejected: l.rejected.Load(),
+	}
+}
+
+// PriorityByPrefix classifies requests by the longest matching path prefix.
+// Unmatched requests are PriorityNormal.
+func PriorityByPrefix(classes map[string]Priority) func(*http.Request) Priority {
+	return func(r *http.Request) Priority {
+		p, match := PriorityNormal, -1
+		for prefix, class := range classes {
+			if strings.HasPrefix(r.URL.Path, prefix) && len(prefix) > match {
+				p, match = class, len(prefix)
+			}
+		}
+		return p
+	}
+}
+
+// MiddlewareLimit applies admission control with the limiter.
+// Rejected requests are answered with 503 and Retry-After,
+// the rejection and limiter stats are added to the MiddlewareBase response record.
+// Responses with a 5xx status are reported to the limiter as failed.
+func MiddlewareLimit(limiter Limiter, classify func(*http.Request) Priority, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := PriorityNormal
+		if classify != nil {
+			p = classify(r)
+		}
+
+		if p >= PriorityCritical {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		release, err := limiter.Acquire(r.Context(), p)
+		if err != nil {
+			stats := limiter.Stats()
+			AddLogAttrs(
+				r.Context(),
+				slog.Bool("limit_rejected", true),
+				slog.String("limit_reason", err.Error()),
+				slog.Int("limit", stats.Limit),
+				slog.Int("limit_inflight", stats.InFlight),
+				slog.Uint64("limit_rejected_total", stats.Rejected),
+			)
+
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "service overloaded", http.StatusServiceUnavailable)
+			return
+		}
+
+		i := newIntercept(w)
+		defer func() { release(i.StatusCode >= http.StatusInternalServerError) }()
+		next.ServeHTTP(i, r)
+	})
+}
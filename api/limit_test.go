@@ -0,0 +1,117 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api"
+)
+
+func TestFixedLimiter(t *testing.T) {
+	limiter := api.NewFixedLimiter(1, 1, 20*time.Millisecond)
+	ctx := context.Background()
+
+	release, err := limiter.Acquire(ctx, api.PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := limiter.Acquire(ctx, api.PriorityLow); err != api.ErrLimitExceeded {
+		t.Errorf("low priority want=%v got=%v", api.ErrLimitExceeded, err)
+	}
+
+	if _, err := limiter.Acquire(ctx, api.PriorityNormal); err != api.ErrLimitTimeout {
+		t.Errorf("queued want=%v got=%v", api.ErrLimitTimeout, err)
+	}
+
+	release(false)
+	if _, err := limiter.Acquire(ctx, api.PriorityNormal); err != nil {
+		t.Errorf("released want=nil got=%v", err)
+	}
+
+	if got := limiter.Stats().Rejected; got != 2 {
+		t.Errorf("rejected want=2 got=%d", got)
+	}
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	for _, bounds := range [][3]int{{1, 0, 2}, {1, 2, 3}, {3, 1, 2}} {
+		if _, err := api.NewAdaptiveLimiter(bounds[0], bounds[1], bounds[2], time.Millisecond); !errors.Is(err, api.ErrLimitInvalid) {
+			t.Errorf("bounds initial=%d min=%d max=%d want=ErrLimitInvalid got=%v", bounds[0], bounds[1], bounds[2], err)
+		}
+	}
+
+	limiter, err := api.NewAdaptiveLimiter(10, 2, 20, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for range 10 {
+		release, err := limiter.Acquire(ctx, api.PriorityNormal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release(true)
+	}
+
+	if got := limiter.Stats().Limit; got >= 10 || got < 2 {
+		t.Errorf("limit after failures want in [2, 10) got=%d", got)
+	}
+
+	for range 100 {
+		release, err := limiter.Acquire(ctx, api.PriorityNormal)
+		if err != nil {
+			t.Fatalf("limiter should never drop below its floor: %v", err)
+		}
+		release(true)
+	}
+
+	if got := limiter.Stats().Limit; got != 2 {
+		t.Errorf("limit at floor want=2 got=%d", got)
+	}
+
+	release, _ := limiter.Acquire(ctx, api.PriorityNormal)
+	second, _ := limiter.Acquire(ctx, api.PriorityNormal)
+	if _, err := limiter.Acquire(ctx, api.PriorityNormal); !errors.Is(err, api.ErrLimitExceeded) {
+		t.Errorf("third request at floor want=ErrLimitExceeded got=%v", err)
+	}
+	release(false)
+	second(false)
+}
+
+func TestMiddlewareLimit(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+
+	limiter := api.NewFixedLimiter(0, 0, 0)
+	classify := api.PriorityByPrefix(map[string]api.Priority{"/health": api.PriorityCritical})
+	handler := api.MiddlewareBase(logger, api.MiddlewareLimit(limiter, classify, http.HandlerFunc(handlerOk)))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("critical status want=%d got=%d", http.StatusOK, recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/items", nil))
+	if recorder.Code != http.StatusServiceUnavailable || recorder.Header().Get("Retry-After") == "" {
+		t.Errorf("rejected status want=%d got=%d", http.StatusServiceUnavailable, recorder.Code)
+	}
+
+	if !strings.Contains(out.String(), `"limit_rejected_total":1`) {
+		t.Errorf("rejected metrics missing from log, got=%s", out.String())
+	}
+}
+
+func handlerOk(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemd passes inherited sockets starting at this descriptor.
+const listenFdsStart = 3
+
+// ListenUnix listens on a unix domain socket with the given file permissions.
+// A stale socket file left by a previous process is removed first.
+func ListenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode().Type() == fs.ModeSocket {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, perm); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// SystemdListeners returns the listeners inherited through systemd socket activation.
+// It returns no listeners when the process was not socket activated.
+// The LISTEN_* variables are unset so child processes do not inherit them.
+func SystemdListeners() ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	listeners := make([]net.Listener, 0, n)
+	for i := range n {
+		name := fmt.Sprintf("LISTEN_FD_%d", listenFdsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(listenFdsStart+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("listener %s: %w", name, err)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+// WithH2C adds cleartext HTTP/2 to the protocols of the server, HTTP/1 and HTTP/2 over TLS by default.
+// It is intended for internal traffic where TLS is terminated elsewhere.
+func WithH2C() func(*http.Server) {
+	return func(s *http.Server) {
+		if s.Protocols == nil {
+			s.Protocols = new(http.Protocols)
+			s.Protocols.SetHTTP1(true)
+			s.Protocols.SetHTTP2(true)
+		}
+		s.Protocols.SetUnencryptedHTTP2(true)
+	}
+}
+
+// Serve serves s on every listener at once.
+// When ctx is done or any listener fails the server is shut down on all of them,
+// waiting up to timeout for active requests.
+// Servers with certificates configured in TLSConfig serve TLS.
+func Serve(ctx context.Context, s *http.Server, timeout time.Duration, listeners ...net.Listener) error {
+	useTLS := s.TLSConfig != nil && (len(s.TLSConfig.Certificates) > 0 || s.TLSConfig.GetCertificate != nil)
+
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			if useTLS {
+				errs <- s.ServeTLS(l, "", "")
+				return
+			}
+			errs <- s.Serve(l)
+		}()
+	}
+
+	var err error
+	pending := len(listeners)
+
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+		pending--
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	shutdownErr := s.Shutdown(shutdownCtx)
+
+	for range pending {
+		if e := <-errs; err == nil {
+			err = e
+		}
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+
+	return errors.Join(err, shutdownErr)
+}
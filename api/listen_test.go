@@ -0,0 +1,94 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api"
+)
+
+func TestServeListeners(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "api.sock")
+	unix, err := api.ListenUnix(socket, 0o660)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info, _ := os.Stat(socket); info.Mode().Perm() != 0o660 {
+		t.Errorf("socket permissions want=%v got=%v", os.FileMode(0o660), info.Mode().Perm())
+	}
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := api.New("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "HTTP/%d", r.ProtoMajor)
+	}), api.WithH2C())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- api.Serve(ctx, server, time.Second, unix, tcp) }()
+
+	h2c := &http.Protocols{}
+	h2c.SetUnencryptedHTTP2(true)
+
+	clients := map[string]*http.Client{
+		"HTTP/1": {Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		}}},
+		"HTTP/2": {Transport: &http.Transport{Protocols: h2c}},
+	}
+
+	for want, c := range clients {
+		resp, err := c.Get("http://" + tcp.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(data) != want {
+			t.Errorf("serve protocol want=%s got=%s", want, data)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("serve shutdown want=nil got=%v", err)
+	}
+}
+
+func TestWithH2CKeepsProtocols(t *testing.T) {
+	server := api.New("", http.HandlerFunc(handlerOk), api.WithH2C())
+	if p := server.Protocols; !p.HTTP1() || !p.HTTP2() || !p.UnencryptedHTTP2() {
+		t.Errorf("h2c should add to the default protocols got=%s", p)
+	}
+
+	http1 := func(s *http.Server) {
+		s.Protocols = new(http.Protocols)
+		s.Protocols.SetHTTP1(true)
+	}
+	server = api.New("", http.HandlerFunc(handlerOk), http1, api.WithH2C())
+	if p := server.Protocols; !p.HTTP1() || p.HTTP2() || !p.UnencryptedHTTP2() {
+		t.Errorf("h2c should keep the configured protocols got=%s", p)
+	}
+}
+
+func TestSystemdListenersNotActivated(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+
+	listeners, err := api.SystemdListeners()
+	if err != nil || len(listeners) != 0 {
+		t.Errorf("systemd listeners want none got=%v err=%v", listeners, err)
+	}
+}
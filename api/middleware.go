@@ -6,14 +6,50 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/bhmt/tittlemanscrest/api/helper"
+	"github.com/bhmt/tittlemanscrest/logging"
 )
 
-type requestIdContextKeyType struct{}
+type logAttrsContextKeyType struct{}
 
-var requestIdContextKey = requestIdContextKeyType{}
+var logAttrsContextKey = logAttrsContextKeyType{}
+
+type bodyContextKeyType struct{}
+
+var bodyContextKey = bodyContextKeyType{}
+
+type logAttrs struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// AddLogAttrs appends attributes to the response record written by MiddlewareBase.
+// It is a no-op when the request is not served through MiddlewareBase.
+func AddLogAttrs(ctx context.Context, attrs ...slog.Attr) {
+	l, ok := ctx.Value(logAttrsContextKey).(*logAttrs)
+	if !ok {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attrs = append(l.attrs, attrs...)
+}
+
+func (l *logAttrs) get() []slog.Attr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.attrs
+}
+
+// bufferedBody returns the request body buffered by MiddlewareBase.
+func bufferedBody(ctx context.Context) ([]byte, bool) {
+	buf, ok := ctx.Value(bodyContextKey).([]byte)
+	return buf, ok
+}
 
 func MiddlewareRest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -22,21 +58,39 @@ func MiddlewareRest(next http.Handler) http.Handler {
 	})
 }
 
-func MiddlewareBase(logger *slog.Logger, next http.Handler) http.Handler {
+// MiddlewareBase buffers the request body, assigns the request id and writes the access log.
+// Handlers log through logging.FromContext with the request_id and trace_id attributes.
+// The access log is configured with the AccessLog options.
+func MiddlewareBase(logger *slog.Logger, next http.Handler, opts ...func(*AccessLog)) http.Handler {
+	access := newAccessLog(opts...)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		buf, _ := io.ReadAll(r.Body)
-		r.Body = io.NopCloser(bytes.NewBuffer(buf))
+		ctx := r.Context()
+		if !access.streamed(r) {
+			buf, _ := io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewBuffer(buf))
+			ctx = context.WithValue(ctx, bodyContextKey, buf)
+		}
 
 		i := newIntercept(w)
 
 		requestId := helper.GetHeaderRequestId(r)
-		r = r.WithContext(context.WithValue(r.Context(), requestIdContextKey, requestId))
+		trace := helper.GetHeaderTrace(r)
+		extra := &logAttrs{}
+		ctx = helper.SetCtxRequestId(ctx, requestId)
+		ctx = helper.SetCtxTrace(ctx, trace)
+		ctx = logging.WithRequest(ctx, logger, requestId, trace.TraceId())
+		ctx = context.WithValue(ctx, logAttrsContextKey, extra)
+		r = r.WithContext(ctx)
+
+		if access.excluded(r) {
+			next.ServeHTTP(i, r)
+			return
+		}
 
 		start := time.Now()
-		logger.LogAttrs(
-			r.Context(),
-			slog.LevelInfo,
-			"request",
+		level := access.level(r)
+		requestAttrs := []slog.Attr{
 			slog.String("request_id", requestId),
 			slog.Time("time", start.UTC()),
 			slog.String("method", r.Method),
@@ -44,18 +98,44 @@ func MiddlewareBase(logger *slog.Logger, next http.Handler) http.Handler {
 			slog.String("path", r.URL.Path),
 			slog.String("query", r.URL.RawQuery),
 			slog.String("ip", r.RemoteAddr),
-		)
+		}
+
+		deferred := access.format != LogFormatSplit || access.sampling()
+		if !deferred {
+			logger.LogAttrs(r.Context(), level, "request", requestAttrs...)
+		}
 
 		next.ServeHTTP(i, r)
 
 		end := time.Now()
-		logger.LogAttrs(
-			r.Context(),
-			slog.LevelInfo,
-			"response",
+		duration := end.Sub(start)
+		if deferred && !access.keep(i.StatusCode, duration) {
+			return
+		}
+
+		if i.StatusCode >= http.StatusInternalServerError || (access.slow > 0 && duration >= access.slow) {
+			level = max(level, slog.LevelInfo)
+		}
+
+		responseAttrs := append([]slog.Attr{
 			slog.String("request_id", requestId),
 			slog.Time("time", end.UTC()),
-			slog.Duration("duration", end.Sub(start)),
-			slog.Int("status", i.StatusCode))
+			slog.Duration("duration", duration),
+			slog.Int("status", i.StatusCode),
+		}, extra.get()...)
+
+		switch access.format {
+		case LogFormatCommon, LogFormatCombined:
+			if logger.Enabled(r.Context(), level) {
+				access.writeCommon(r, i, start)
+			}
+		case LogFormatAccess:
+			logger.LogAttrs(r.Context(), level, "access", append(requestAttrs, responseAttrs[2:]...)...)
+		default:
+			if deferred {
+				logger.LogAttrs(r.Context(), level, "request", requestAttrs...)
+			}
+			logger.LogAttrs(r.Context(), level, "response", responseAttrs...)
+		}
 	})
 }
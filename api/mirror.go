@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api/helper"
+)
+
+// Mirror configures MiddlewareMirror.
+// By default every request is mirrored, at most 16 at a time, with a 5 second timeout.
+type Mirror struct {
+	target  *url.URL
+	client  *http.Client
+	rate    float64
+	sem     chan struct{}
+	timeout time.Duration
+	compare bool
+	limit   int
+}
+
+// WithMirrorSampling mirrors the fraction of requests, between 0 and 1.
+func WithMirrorSampling(rate float64) func(*Mirror) {
+	return func(m *Mirror) {
+		m.rate = rate
+	}
+}
+
+// WithMirrorConcurrency bounds the shadow requests in flight,
+// requests arriving while the bound is reached are not mirrored.
+func WithMirrorConcurrency(n int) func(*Mirror) {
+	return func(m *Mirror) {
+		m.sem = make(chan struct{}, n)
+	}
+}
+
+func WithMirrorTimeout(timeout time.Duration) func(*Mirror) {
+	return func(m *Mirror) {
+		m.timeout = timeout
+	}
+}
+
+func WithMirrorClient(client *http.Client) func(*Mirror) {
+	return func(m *Mirror) {
+		m.client = client
+	}
+}
+
+// WithMirrorCompare diffs the shadow status and body against the primary response.
+// Bodies larger than limit bytes are compared by status only.
+func WithMirrorCompare(limit int) func(*Mirror) {
+	return func(m *Mirror) {
+		m.compare = true
+		m.limit = limit
+	}
+}
+
+// MiddlewareMirror replays a sampled copy of each request to the target after the primary response is written.
+// Request bodies are taken from MiddlewareBase, requests with a body it did not buffer are not mirrored.
+// Shadow responses are discarded, failures and mismatches are logged with the request id.
+func MiddlewareMirror(logger *slog.Logger, target *url.URL, next http.Handler, opts ...func(*Mirror)) http.Handler {
+	m := Mirror{
+		target:  target,
+		client:  http.DefaultClient,
+		rate:    1,
+		sem:     make(chan struct{}, 16),
+		timeout: 5 * time.Second,
+	}
+	for _, o := range opts {
+		o(&m)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, buffered := bufferedBody(r.Context())
+		if !buffered && r.Body != nil && r.Body != http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.rate < 1 && rand.Float64() >= m.rate {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case m.sem <- struct{}{}:
+		default:
+			AddLogAttrs(r.Context(), slog.Bool("mirror_dropped", true))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var i *intercept
+		if m.compare {
+			i = newTeeIntercept(w, m.limit+1)
+			w = i
+		}
+
+		// The slot is released here unless the replay takes it over, e.g. when next panics.
+		replaying := false
+		defer func() {
+			if !replaying {
+				<-m.sem
+			}
+		}()
+
+		shadow := m.request(r, body)
+		next.ServeHTTP(w, r)
+
+		replaying = true
+		go func() {
+			defer func() { <-m.sem }()
+			m.replay(logger, shadow, i)
+		}()
+	})
+}
+
+// request copies r for the target, detached from the primary request context.
+func (m *Mirror) request(r *http.Request, body []byte) *http.Request {
+	ctx := context.WithoutCancel(r.Context())
+
+	u := *r.URL
+	u.Scheme = m.target.Scheme
+	u.Host = m.target.Host
+	u.Path = strings.TrimSuffix(m.target.Path, "/") + r.URL.Path
+	u.RawPath = ""
+
+	shadow, _ := http.NewRequestWithContext(ctx, r.Method, u.String(), bytes.NewReader(body))
+	shadow.Header = r.Header.Clone()
+	helper.SetHeaderRequestId(shadow.Header, helper.CtxRequestId(ctx))
+	helper.SetHeaderTrace(shadow.Header, helper.CtxTrace(ctx))
+	shadow.Header.Set("X-Mirrored", "true")
+	return shadow
+}
+
+func (m *Mirror) replay(logger *slog.Logger, shadow *http.Request, primary *intercept) {
+	ctx, cancel := context.WithTimeout(shadow.Context(), m.timeout)
+	defer cancel()
+
+	attrs := []slog.Attr{
+		slog.String("request_id", helper.CtxRequestId(ctx)),
+		slog.String("method", shadow.Method),
+		slog.String("path", shadow.URL.Path),
+	}
+
+	resp, err := m.client.Do(shadow.WithContext(ctx))
+	if err != nil {
+		logger.LogAttrs(ctx, slog.LevelWarn, "mirror failed", append(attrs, slog.String("error", err.Error()))...)
+		return
+	}
+	defer resp.Body.Close()
+
+	if primary == nil {
+		io.Copy(io.Discard, resp.Body)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(m.limit)+1))
+	if err != nil {
+		logger.LogAttrs(ctx, slog.LevelWarn, "mirror failed", append(attrs, slog.String("error", err.Error()))...)
+		return
+	}
+
+	statusMatch := resp.StatusCode == primary.StatusCode
+	bodyMatch := true
+	if primary.Bytes <= m.limit && len(body) <= m.limit {
+		bodyMatch = bytes.Equal(body, primary.Tee.Bytes())
+	}
+
+	if statusMatch && bodyMatch {
+		return
+	}
+
+	logger.LogAttrs(ctx, slog.LevelWarn, "mirror mismatch", append(attrs,
+		slog.Int("status", primary.StatusCode),
+		slog.Int("mirror_status", resp.StatusCode),
+		slog.Bool("body_match", bodyMatch),
+	)...)
+}
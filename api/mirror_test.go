@@ -0,0 +1,132 @@
+package api_test
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestMiddlewareMirror(t *testing.T) {
+	received := make(chan string, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r.URL.Path + " " + string(body) + " " + r.Header.Get("X-Request-Id")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer shadow.Close()
+
+	target, _ := url.Parse(shadow.URL)
+	out := &syncBuffer{}
+	logger := slog.New(slog.NewJSONHandler(out, nil))
+
+	primary := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		w.Write([]byte("primary"))
+	})
+	handler := api.MiddlewareBase(slog.New(slog.DiscardHandler), api.MiddlewareMirror(logger, target, primary, api.WithMirrorCompare(1024)))
+
+	request := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader("antigravity"))
+	request.Header.Set("X-Request-Id", "mirror-1")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "primary" {
+		t.Errorf("primary response changed got=%d %s", recorder.Code, recorder.Body.String())
+	}
+
+	select {
+	case got := <-received:
+		if want := "/items antigravity mirror-1"; got != want {
+			t.Errorf("shadow request want=%q got=%q", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shadow request not sent")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(out.String(), "mirror mismatch") && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	logged := out.String()
+	for _, want := range []string{`"request_id":"mirror-1"`, `"mirror_status":418`, `"body_match":false`} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("mismatch record missing %s got=%s", want, logged)
+		}
+	}
+}
+
+func TestMiddlewareMirrorSampling(t *testing.T) {
+	received := make(chan struct{}, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+	}))
+	defer shadow.Close()
+
+	target, _ := url.Parse(shadow.URL)
+	handler := api.MiddlewareMirror(slog.New(slog.DiscardHandler), target, http.HandlerFunc(handlerOk), api.WithMirrorSampling(0))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	select {
+	case <-received:
+		t.Error("request mirrored with zero sampling")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMiddlewareMirrorPanic(t *testing.T) {
+	received := make(chan struct{}, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+	}))
+	defer shadow.Close()
+
+	target, _ := url.Parse(shadow.URL)
+	panics := true
+	primary := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if panics {
+			panic("antigravity")
+		}
+	})
+	handler := api.MiddlewareMirror(slog.New(slog.DiscardHandler), target, primary, api.WithMirrorConcurrency(1))
+
+	func() {
+		defer func() { recover() }()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+
+	panics = false
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Error("mirror slot leaked by a panicking handler")
+	}
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api/helper"
+	"github.com/bhmt/tittlemanscrest/cache"
+)
+
+var ErrRateInvalid = fmt.Errorf("invalid rate limit")
+
+// RateState is the per key state kept by a RateStore.
+// Token buckets use Tokens and Last, sliding windows use Window, Count and Prev.
+type RateState struct {
+	Tokens float64
+	Last   time.Time
+	Window time.Time
+	Count  int64
+	Prev   int64
+}
+
+// RateStore keeps rate limiter state.
+// Update must apply fn to the state of key atomically and persist the result,
+// a missing key starts from the zero state.
+type RateStore interface {
+	Update(ctx context.Context, key string, fn func(*RateState)) error
+}
+
+// MemoryRateStore keeps rate limiter state in a bounded LRU.
+type MemoryRateStore struct {
+	lru *cache.LRU[string, RateState]
+	mu  sync.Mutex
+}
+
+func NewMemoryRateStore(lru *cache.LRU[string, RateState]) *MemoryRateStore {
+	return &MemoryRateStore{lru: lru}
+}
+
+func (m *MemoryRateStore) Update(ctx context.Context, key string, fn func(*RateState)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var state RateState
+	if s, ok := m.lru.Get(key); ok {
+		state = *s
+	}
+
+	fn(&state)
+	m.lru.Add(key, state)
+	return nil
+}
+
+type RateDecision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// RateAlgorithm decides on a request from the state of its key.
+// Algorithms implementing Validate() error are checked by NewRateLimiter and SetAlgorithm.
+type RateAlgorithm interface {
+	Take(s *RateState, now time.Time) RateDecision
+}
+
+func validateRate(algorithm RateAlgorithm) error {
+	if v, ok := algorithm.(interface{ Validate() error }); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// TokenBucket refills Rate tokens per second up to Burst.
+type TokenBucket struct {
+	Rate  float64
+	Burst int
+}
+
+// Validate rejects a bucket that never refills or never allows a request.
+func (b TokenBucket) Validate() error {
+	if b.Rate <= 0 || b.Burst < 1 {
+		return fmt.Errorf("%w: token bucket rate %g burst %d", ErrRateInvalid, b.Rate, b.Burst)
+	}
+	return nil
+}
+
+func (b TokenBucket) Take(s *RateState, now time.Time) RateDecision {
+	burst := float64(b.Burst)
+	if s.Last.IsZero() {
+		s.Tokens = burst
+	} else {
+		s.Tokens = min(burst, s.Tokens+now.Sub(s.Last).Seconds()*b.Rate)
+	}
+	s.Last = now
+
+	d := RateDecision{Limit: b.Burst}
+	if s.Tokens >= 1 {
+		s.Tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = seconds((1 - s.Tokens) / b.Rate)
+	}
+
+	d.Remaining = int(s.Tokens)
+	d.Reset = seconds((burst - s.Tokens) / b.Rate)
+	return d
+}
+
+// SlidingWindow allows Limit requests per Window.
+// The count of the previous window is weighted by its overlap with the sliding window.
+type SlidingWindow struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Validate rejects a window that never allows a request.
+func (sw SlidingWindow) Validate() error {
+	if sw.Limit < 1 || sw.Window <= 0 {
+		return fmt.Errorf("%w: sliding window limit %d window %s", ErrRateInvalid, sw.Limit, sw.Window)
+	}
+	return nil
+}
+
+func (sw SlidingWindow) Take(s *RateState, now time.Time) RateDecision {
+	window := now.Truncate(sw.Window)
+	if !s.Window.Equal(window) {
+		if window.Sub(s.Window) == sw.Window {
+			s.Prev = s.Count
+		} else {
+			s.Prev = 0
+		}
+		s.Count = 0
+		s.Window = window
+	}
+
+	elapsed := now.Sub(window)
+	weight := 1 - float64(elapsed)/float64(sw.Window)
+	estimate := int(math.Ceil(float64(s.Prev)*weight)) + int(s.Count)
+
+	d := RateDecision{Limit: sw.Limit, Reset: sw.Window - elapsed}
+	if estimate < sw.Limit {
+		s.Count++
+		estimate++
+		d.Allowed = true
+	} else {
+		d.RetryAfter = d.Reset
+	}
+
+	d.Remaining = max(0, sw.Limit-estimate)
+	return d
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+type RateLimiter struct {
+	store RateStore
+	key   func(*http.Request) string
+
+	mu        sync.RWMutex
+	algorithm RateAlgorithm
+}
+
+// NewRateLimiter limits requests per key, requests with an empty key are not limited.
+func NewRateLimiter(store RateStore, algorithm RateAlgorithm, key func(*http.Request) string) (*RateLimiter, error) {
+	if err := validateRate(algorithm); err != nil {
+		return nil, err
+	}
+	return &RateLimiter{store: store, algorithm: algorithm, key: key}, nil
+}
+
+// SetAlgorithm replaces the limits at runtime, e.g. on configuration reload.
+// Stored state is kept, so a client keeps its used budget. Invalid limits leave the current ones.
+func (l *RateLimiter) SetAlgorithm(algorithm RateAlgorithm) error {
+	if err := validateRate(algorithm); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.algorithm = algorithm
+	return nil
+}
+
+func (l *RateLimiter) Take(ctx context.Context, key string) (RateDecision, error) {
+	l.mu.RLock()
+	algorithm := l.algorithm
+	l.mu.RUnlock()
+
+	var d RateDecision
+	err := l.store.Update(ctx, key, func(s *RateState) {
+		d = algorithm.Take(s, time.Now())
+	})
+	return d, err
+}
+
+// RateKeyIp keys on the connected peer address.
+// Behind a proxy use RateKeyForwarded so clients are not collapsed into one key.
+func RateKeyIp(r *http.Request) string {
+	return helper.RemoteIp(r)
+}
+
+// RateKeyForwarded keys on the client address resolved from
+// X-Forwarded-For through the trusted proxies.
+func RateKeyForwarded(trusted ...netip.Prefix) func(*http.Request) string {
+	return func(r *http.Request) string {
+		return helper.ForwardedIp(r, trusted)
+	}
+}
+
+func RateKeyHeader(name string) func(*http.Request) string {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// MiddlewareRateLimit answers requests over the limit with 429 and Retry-After.
+// Every limited response carries the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+// Store errors let the request through and are added to the MiddlewareBase response record.
+func MiddlewareRateLimit(limiter *RateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := limiter.key(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		d, err := limiter.Take(r.Context(), key)
+		if err != nil {
+			AddLogAttrs(r.Context(), slog.String("ratelimit_error", err.Error()))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		h.Set("RateLimit-Reset", ceilSeconds(d.Reset))
+
+		if !d.Allowed {
+			AddLogAttrs(r.Context(), slog.Bool("ratelimit_rejected", true))
+			h.Set("Retry-After", ceilSeconds(d.RetryAfter))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
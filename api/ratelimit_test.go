@@ -0,0 +1,165 @@
+package api_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api"
+	"github.com/bhmt/tittlemanscrest/cache"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := api.TokenBucket{Rate: 1, Burst: 2}
+	state := api.RateState{}
+	now := time.Now()
+
+	tests := []struct {
+		at   time.Duration
+		want bool
+	}{
+		{at: 0, want: true},
+		{at: 0, want: true},
+		{at: 0, want: false},
+		{at: time.Second, want: true},
+		{at: time.Second, want: false},
+	}
+
+	for i, test := range tests {
+		if got := bucket.Take(&state, now.Add(test.at)).Allowed; got != test.want {
+			t.Errorf("token bucket take %d want=%v got=%v", i, test.want, got)
+		}
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	window := api.SlidingWindow{Limit: 2, Window: time.Minute}
+	state := api.RateState{}
+	start := time.Now().Truncate(time.Minute)
+
+	tests := []struct {
+		at   time.Duration
+		want bool
+	}{
+		{at: 0, want: true},
+		{at: time.Second, want: true},
+		{at: 2 * time.Second, want: false},
+		{at: time.Minute + 10*time.Second, want: false},
+		{at: time.Minute + 40*time.Second, want: true},
+	}
+
+	for i, test := range tests {
+		if got := window.Take(&state, start.Add(test.at)).Allowed; got != test.want {
+			t.Errorf("sliding window take %d want=%v got=%v", i, test.want, got)
+		}
+	}
+}
+
+func TestRateLimiterValidate(t *testing.T) {
+	lru, _ := cache.New[string, api.RateState](16, 0)
+	store := api.NewMemoryRateStore(lru)
+
+	for _, algorithm := range []api.RateAlgorithm{
+		api.TokenBucket{Burst: 1},
+		api.TokenBucket{Rate: 1},
+		api.SlidingWindow{Limit: 1},
+	} {
+		if _, err := api.NewRateLimiter(store, algorithm, api.RateKeyIp); !errors.Is(err, api.ErrRateInvalid) {
+			t.Errorf("%+v want=ErrRateInvalid got=%v", algorithm, err)
+		}
+	}
+}
+
+func TestMiddlewareRateLimit(t *testing.T) {
+	lru, _ := cache.New[string, api.RateState](16, 0)
+	limiter, err := api.NewRateLimiter(
+		api.NewMemoryRateStore(lru),
+		api.TokenBucket{Rate: 0.1, Burst: 1},
+		api.RateKeyHeader("X-Api-Key"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := api.MiddlewareRateLimit(limiter, http.HandlerFunc(handlerOk))
+
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{key: "a", want: http.StatusOK},
+		{key: "a", want: http.StatusTooManyRequests},
+		{key: "b", want: http.StatusOK},
+		{key: "", want: http.StatusOK},
+		{key: "", want: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Api-Key", test.key)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		if recorder.Code != test.want {
+			t.Errorf("rate limit status for key %q want=%d got=%d", test.key, test.want, recorder.Code)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("X-Api-Key", "a")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if got := recorder.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("retry after want=10 got=%q", got)
+	}
+	if got := recorder.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("remaining want=0 got=%q", got)
+	}
+
+	if err := limiter.SetAlgorithm(api.SlidingWindow{Window: time.Minute}); !errors.Is(err, api.ErrRateInvalid) {
+		t.Errorf("zero limit want=ErrRateInvalid got=%v", err)
+	}
+	if err := limiter.SetAlgorithm(api.SlidingWindow{Limit: 10, Window: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK || recorder.Header().Get("RateLimit-Limit") != "10" {
+		t.Errorf("replaced algorithm want=%d limit=10 got=%d limit=%s", http.StatusOK, recorder.Code, recorder.Header().Get("RateLimit-Limit"))
+	}
+}
+
+func TestRateKeyIp(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		remote    string
+		forwarded string
+		ip        string
+		forward   string
+	}{
+		{remote: "192.0.2.1:1234", forwarded: "", ip: "192.0.2.1", forward: "192.0.2.1"},
+		{remote: "192.0.2.1:1234", forwarded: "203.0.113.7", ip: "192.0.2.1", forward: "192.0.2.1"},
+		{remote: "10.0.0.2:1234", forwarded: "203.0.113.7", ip: "10.0.0.2", forward: "203.0.113.7"},
+		{remote: "10.0.0.2:1234", forwarded: "198.51.100.9, 203.0.113.7, 10.0.0.3", ip: "10.0.0.2", forward: "203.0.113.7"},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = test.remote
+		if test.forwarded != "" {
+			request.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+
+		if got := api.RateKeyIp(request); got != test.ip {
+			t.Errorf("ip key for %s %q want=%s got=%s", test.remote, test.forwarded, test.ip, got)
+		}
+		if got := api.RateKeyForwarded(trusted...)(request); got != test.forward {
+			t.Errorf("forwarded key for %s %q want=%s got=%s", test.remote, test.forwarded, test.forward, got)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"time"
@@ -82,6 +83,11 @@ func WithErrorLog(val *log.Logger) func(*http.Server) {
 	}
 }
 
+// WithErrorLogger writes the server errors, e.g. failed TLS handshakes, to logger at error level.
+func WithErrorLogger(logger *slog.Logger) func(*http.Server) {
+	return WithErrorLog(slog.NewLogLogger(logger.Handler(), slog.LevelError))
+}
+
 func WithBaseContext(val func(net.Listener) context.Context) func(*http.Server) {
 	return func(s *http.Server) {
 		s.BaseContext = val
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoCertificates = fmt.Errorf("no certificates found in CA bundle")
+
+// CertReloader serves a certificate pair loaded from PEM files.
+// Reload swaps the pair without interrupting established connections.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	c := CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.cert.Store(&cert)
+	return nil
+}
+
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// Watch polls the files every interval and reloads them when a modification time changes.
+// Failed reloads are logged and the previous certificate is kept.
+func (c *CertReloader) Watch(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+	watchFiles(ctx, logger, interval, c.Reload, c.certFile, c.keyFile)
+}
+
+// CAReloader serves a client CA pool loaded from a PEM bundle.
+type CAReloader struct {
+	caFile string
+	pool   atomic.Pointer[x509.CertPool]
+}
+
+func NewCAReloader(caFile string) (*CAReloader, error) {
+	c := CAReloader{caFile: caFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *CAReloader) Reload() error {
+	data, err := os.ReadFile(c.caFile)
+	if err != nil {
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return ErrNoCertificates
+	}
+
+	c.pool.Store(pool)
+	return nil
+}
+
+func (c *CAReloader) Pool() *x509.CertPool {
+	return c.pool.Load()
+}
+
+func (c *CAReloader) Watch(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+	watchFiles(ctx, logger, interval, c.Reload, c.caFile)
+}
+
+func watchFiles(ctx context.Context, logger *slog.Logger, interval time.Duration, reload func() error, files ...string) {
+	modTimes := func() string {
+		var b strings.Builder
+		for _, f := range files {
+			if info, err := os.Stat(f); err == nil {
+				b.WriteString(info.ModTime().String())
+			}
+		}
+		return b.String()
+	}
+
+	last := modTimes()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := modTimes()
+			if current == last {
+				continue
+			}
+
+			if err := reload(); err != nil {
+				logger.ErrorContext(ctx, "tls reload failed", slog.Any("files", files), slog.String("error", err.Error()))
+				continue
+			}
+
+			last = current
+			logger.InfoContext(ctx, "tls reloaded", slog.Any("files", files))
+		}
+	}
+}
+
+// WithCertReloader serves certificates from the reloader.
+// When clientCAs is set, clients must present a certificate verified by the current CA pool.
+// It extends a config set by WithTLSConfig, so it should be passed after it.
+func WithCertReloader(certs *CertReloader, clientCAs *CAReloader) func(*http.Server) {
+	return func(s *http.Server) {
+		base := &tls.Config{MinVersion: tls.VersionTLS12}
+		if s.TLSConfig != nil {
+			base = s.TLSConfig.Clone()
+		}
+
+		base.GetCertificate = certs.GetCertificate
+		base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := base.Clone()
+			cfg.GetConfigForClient = nil
+			if clientCAs != nil {
+				cfg.ClientCAs = clientCAs.Pool()
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		}
+
+		s.TLSConfig = base
+	}
+}
+
+// ClientIdentity is the identity of a verified client certificate.
+type ClientIdentity struct {
+	SPIFFE     string
+	CommonName string
+	DNSNames   []string
+	URIs       []string
+}
+
+// Id returns the SPIFFE id when present, otherwise the common name.
+func (c ClientIdentity) Id() string {
+	if c.SPIFFE != "" {
+		return c.SPIFFE
+	}
+	return c.CommonName
+}
+
+type clientIdentityContextKeyType struct{}
+
+var clientIdentityContextKey = clientIdentityContextKeyType{}
+
+func CtxClientIdentity(ctx context.Context) (ClientIdentity, bool) {
+	id, ok := ctx.Value(clientIdentityContextKey).(ClientIdentity)
+	return id, ok
+}
+
+// MiddlewareClientIdentity stores the identity of a verified client certificate in the request context
+// and adds it to the MiddlewareBase response record.
+// Requests without a verified certificate pass through unchanged.
+func MiddlewareClientIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cert := r.TLS.PeerCertificates[0]
+		id := ClientIdentity{
+			CommonName: cert.Subject.CommonName,
+			DNSNames:   cert.DNSNames,
+		}
+
+		for _, u := range cert.URIs {
+			id.URIs = append(id.URIs, u.String())
+			if u.Scheme == "spiffe" && id.SPIFFE == "" {
+				id.SPIFFE = u.String()
+			}
+		}
+
+		AddLogAttrs(
+			r.Context(),
+			slog.String("client_id", id.Id()),
+			slog.Any("client_sans", slices.Concat(id.DNSNames, id.URIs)),
+		)
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIdentityContextKey, id)))
+	})
+}
@@ -0,0 +1,181 @@
+package api_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/api"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	return testCert{cert: cert, key: key, der: der}
+}
+
+func (c testCert) write(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+
+	keyDer, _ := x509.MarshalECPrivateKey(c.key)
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600)
+	if keyFile != "" {
+		os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)
+	}
+}
+
+func (c testCert) pair() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	first := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "first"}}, nil)
+	first.write(t, certFile, keyFile)
+
+	reloader, err := api.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "second"}}, nil)
+	second.write(t, certFile, keyFile)
+
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, _ := reloader.GetCertificate(nil)
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	if leaf.Subject.CommonName != "second" {
+		t.Errorf("reloaded certificate want=second got=%s", leaf.Subject.CommonName)
+	}
+}
+
+func TestMutualTLSClientIdentity(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+
+	ca := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	ca.write(t, caFile, "")
+
+	server := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		DNSNames:    []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+	server.write(t, certFile, keyFile)
+
+	spiffe, _ := url.Parse("spiffe://example.org/worker")
+	client := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "worker"},
+		URIs:        []*url.URL{spiffe},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+
+	certs, err := api.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientCAs, err := api.NewCAReloader(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := api.MiddlewareClientIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := api.CtxClientIdentity(r.Context())
+		w.Write([]byte(id.Id()))
+	}))
+
+	ts := httptest.NewUnstartedServer(handler)
+	api.WithCertReloader(certs, clientCAs)(ts.Config)
+	ts.TLS = ts.Config.TLSConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := []struct {
+		certs   []tls.Certificate
+		want    string
+		wantErr bool
+	}{
+		{certs: []tls.Certificate{client.pair()}, want: spiffe.String()},
+		{certs: nil, wantErr: true},
+	}
+
+	for _, test := range tests {
+		c := http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			ServerName:   "localhost",
+			Certificates: test.certs,
+		}}}
+
+		resp, err := c.Get(ts.URL)
+		if test.wantErr {
+			if err == nil {
+				resp.Body.Close()
+				t.Error("mtls without client certificate should fail")
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(data) != test.want {
+			t.Errorf("client identity want=%s got=%s", test.want, data)
+		}
+	}
+}
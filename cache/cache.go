@@ -74,6 +74,11 @@ func (lru *LRU[K, V]) Add(k K, v V) {
 	lru.m[k] = mapping
 }
 
+// Remove drops the key, e.g. when the cached value is invalidated.
+func (lru *LRU[K, V]) Remove(k K) {
+	lru.remove(k)
+}
+
 func (lru *LRU[K, V]) remove(k K) (*V, bool) {
 	lru.mu.Lock()
 	defer lru.mu.Unlock()
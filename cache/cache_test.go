@@ -203,3 +203,18 @@ func TestCacheNoEvict(t *testing.T) {
 		t.Error("cache no evict not ok")
 	}
 }
+
+func TestCacheRemove(t *testing.T) {
+	lru, err := New[int, struct{}](5, time.Duration(time.Hour))
+	if err != nil {
+		t.Error(err)
+	}
+
+	lru.Add(1, struct{}{})
+	lru.Remove(1)
+	lru.Remove(2)
+
+	if _, ok := lru.Get(1); ok || lru.q.l.Len() != 0 {
+		t.Error("cache Remove not ok")
+	}
+}
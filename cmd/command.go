@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bhmt/tittlemanscrest/config"
+)
+
+var ErrUsage = fmt.Errorf("usage error")
+
+// Command is a node of a command line tree, e.g. "svc serve", "svc migrate up".
+// Config is a pointer to a struct whose `flag` tags become the command flags,
+// it is populated with config.Load using Load before Run is called.
+// Flags registers additional flags. Run receives the arguments left after the flags.
+type Command struct {
+	Name     string
+	Summary  string
+	Config   any
+	Load     []func(*config.Loader)
+	Flags    func(fs *flag.FlagSet)
+	Run      func(ctx context.Context, args []string) error
+	Commands []*Command
+
+	// Output receives help and completion output, os.Stdout by default.
+	Output io.Writer
+}
+
+// Main executes the command with the process arguments and exits with
+// 0 on success or help, 2 on usage errors and 1 on other errors.
+func Main(root *Command) {
+	err := root.Execute(os.Args[1:])
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		os.Exit(0)
+	case errors.Is(err, ErrUsage):
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Execute runs the command selected by args under the signal-aware context of Run.
+func (c *Command) Execute(args []string) error {
+	var err error
+	Run(func(ctx context.Context) { err = c.ExecuteContext(ctx, args) })
+	return err
+}
+
+// ExecuteContext resolves the subcommand, parsing and loading the flags of each command on the way.
+// The help and completion commands are added to the root.
+func (c *Command) ExecuteContext(ctx context.Context, args []string) error {
+	if c.Output == nil {
+		c.Output = os.Stdout
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "help":
+			target, path := c.find(args[1:])
+			target.help(c.Output, path)
+			return nil
+		case "completion":
+			return c.completion(args[1:])
+		case "__complete":
+			c.complete(args[1:])
+			return nil
+		}
+	}
+
+	path := c.Name
+	cmd := c
+	for {
+		fs := cmd.flagSet(path)
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				cmd.help(c.Output, path)
+				return err
+			}
+			return fmt.Errorf("%w: %s: %v", ErrUsage, path, err)
+		}
+
+		if cmd.Config != nil {
+			if err := config.Load(cmd.Config, append(slices.Clone(cmd.Load), config.WithFlags(fs, nil))...); err != nil {
+				return err
+			}
+		}
+
+		args = fs.Args()
+		if len(args) > 0 {
+			if sub := cmd.child(args[0]); sub != nil {
+				if sub.Output == nil {
+					sub.Output = c.Output
+				}
+				cmd, path, args = sub, path+" "+sub.Name, args[1:]
+				continue
+			}
+		}
+
+		if cmd.Run == nil {
+			cmd.help(c.Output, path)
+			if len(args) > 0 {
+				return fmt.Errorf("%w: %s: unknown command %q", ErrUsage, path, args[0])
+			}
+			return fmt.Errorf("%w: %s: missing command", ErrUsage, path)
+		}
+		return cmd.Run(ctx, args)
+	}
+}
+
+func (c *Command) child(name string) *Command {
+	for _, sub := range c.Commands {
+		if sub.Name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// find walks the command names in args, ignoring flags, and returns the deepest command and its path.
+func (c *Command) find(args []string) (*Command, string) {
+	cmd, path := c, c.Name
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		sub := cmd.child(arg)
+		if sub == nil {
+			break
+		}
+		cmd, path = sub, path+" "+sub.Name
+	}
+	return cmd, path
+}
+
+func (c *Command) flagSet(path string) *flag.FlagSet {
+	fs := flag.NewFlagSet(path, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if c.Config != nil {
+		config.RegisterFlags(fs, c.Config)
+	}
+	if c.Flags != nil {
+		c.Flags(fs)
+	}
+	return fs
+}
+
+func (c *Command) help(w io.Writer, path string) {
+	fs := c.flagSet(path)
+
+	usage := path
+	if hasFlags(fs) {
+		usage += " [flags]"
+	}
+	if len(c.Commands) > 0 {
+		usage += " <command>"
+	}
+
+	fmt.Fprintf(w, "Usage: %s\n", usage)
+	if c.Summary != "" {
+		fmt.Fprintf(w, "\n%s\n", c.Summary)
+	}
+
+	if len(c.Commands) > 0 {
+		fmt.Fprintf(w, "\nCommands:\n")
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, sub := range c.Commands {
+			fmt.Fprintf(tw, "  %s\t%s\n", sub.Name, sub.Summary)
+		}
+		tw.Flush()
+	}
+
+	if hasFlags(fs) {
+		fmt.Fprintf(w, "\nFlags:\n")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+}
+
+func hasFlags(fs *flag.FlagSet) bool {
+	has := false
+	fs.VisitAll(func(*flag.Flag) { has = true })
+	return has
+}
+
+// complete prints the subcommands and flags completing the last argument.
+func (c *Command) complete(args []string) {
+	partial := ""
+	if len(args) > 0 {
+		partial, args = args[len(args)-1], args[:len(args)-1]
+	}
+
+	cmd, _ := c.find(args)
+	var candidates []string
+	if cmd == c {
+		candidates = append(candidates, "help", "completion")
+	}
+	for _, sub := range cmd.Commands {
+		candidates = append(candidates, sub.Name)
+	}
+	cmd.flagSet(cmd.Name).VisitAll(func(f *flag.Flag) {
+		candidates = append(candidates, "-"+f.Name)
+	})
+
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, partial) {
+			fmt.Fprintln(c.Output, candidate)
+		}
+	}
+}
+
+// completion prints a bash, zsh or fish script completing the command through its hidden __complete command, e.g.
+//
+//	source <(svc completion bash)
+func (c *Command) completion(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: %s completion <bash|zsh|fish>", ErrUsage, c.Name)
+	}
+
+	name := c.Name
+	fn := "_" + strings.NewReplacer("-", "_", ".", "_").Replace(name)
+	switch args[0] {
+	case "bash":
+		fmt.Fprintf(c.Output, `%[2]s() {
+    local IFS=$'\n'
+    COMPREPLY=($(%[1]s __complete "${COMP_WORDS[@]:1:COMP_CWORD}"))
+}
+complete -o default -F %[2]s %[1]s
+`, name, fn)
+	case "zsh":
+		fmt.Fprintf(c.Output, `#compdef %[1]s
+%[2]s() {
+    local -a candidates
+    candidates=("${(@f)$(%[1]s __complete "${(@)words[2,CURRENT]}")}")
+    compadd -a candidates
+}
+compdef %[2]s %[1]s
+`, name, fn)
+	case "fish":
+		fmt.Fprintf(c.Output, "complete -c %[1]s -f -a '(%[1]s __complete (commandline -opc)[2..-1] (commandline -ct))'\n", name)
+	default:
+		return fmt.Errorf("%w: unsupported shell %q", ErrUsage, args[0])
+	}
+	return nil
+}
+
+// VersionCommand prints the module version and the VCS revision the binary was built from.
+func VersionCommand() *Command {
+	c := &Command{Name: "version", Summary: "print the build version"}
+	c.Run = func(ctx context.Context, args []string) error {
+		info, ok := debug.ReadBuildInfo()
+		if !ok {
+			return fmt.Errorf("build info not available")
+		}
+
+		out := c.Output
+		if out == nil {
+			out = os.Stdout
+		}
+
+		fmt.Fprintf(out, "%s %s %s\n", info.Path, info.Main.Version, info.GoVersion)
+		for _, s := range info.Settings {
+			if strings.HasPrefix(s.Key, "vcs.") {
+				fmt.Fprintf(out, "%s %s\n", s.Key, s.Value)
+			}
+		}
+		return nil
+	}
+	return c
+}
@@ -0,0 +1,144 @@
+package cmd_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/bhmt/tittlemanscrest/cmd"
+	"github.com/bhmt/tittlemanscrest/config"
+)
+
+type serveConfig struct {
+	Addr    string   `flag:"addr" default:":8081" usage:"listen address"`
+	Brokers []string `env:"BROKERS" flag:"brokers" usage:"kafka brokers"`
+}
+
+func tree(out *bytes.Buffer, cfg *serveConfig, ran *[]string) *cmd.Command {
+	verbose := new(bool)
+	return &cmd.Command{
+		Name:    "svc",
+		Summary: "Example service.",
+		Output:  out,
+		Flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(verbose, "v", false, "verbose logging")
+		},
+		Commands: []*cmd.Command{
+			{
+				Name:    "serve",
+				Summary: "serve http",
+				Config:  cfg,
+				Load:    []func(*config.Loader){config.WithEnv(func(string) (string, bool) { return "env:9092", true })},
+				Run: func(ctx context.Context, args []string) error {
+					*ran = append(*ran, "serve "+strings.Join(args, " "))
+					return nil
+				},
+			},
+			{
+				Name:    "migrate",
+				Summary: "manage the schema",
+				Commands: []*cmd.Command{
+					{Name: "up", Summary: "apply migrations", Run: func(ctx context.Context, args []string) error {
+						if !*verbose {
+							return errors.New("root flag not parsed")
+						}
+						*ran = append(*ran, "migrate up")
+						return nil
+					}},
+				},
+			},
+		},
+	}
+}
+
+func TestCommandExecute(t *testing.T) {
+	var out bytes.Buffer
+	var cfg serveConfig
+	var ran []string
+	root := tree(&out, &cfg, &ran)
+
+	if err := root.ExecuteContext(context.Background(), []string{"serve", "-addr", ":9000", "extra"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":9000" || len(cfg.Brokers) != 1 || cfg.Brokers[0] != "env:9092" {
+		t.Errorf("config not loaded got=%+v", cfg)
+	}
+
+	if err := root.ExecuteContext(context.Background(), []string{"-v", "migrate", "up"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ran, ",") != "serve extra,migrate up" {
+		t.Errorf("ran want=%q got=%q", "serve extra,migrate up", ran)
+	}
+
+	if err := root.ExecuteContext(context.Background(), []string{"migrate", "sideways"}); !errors.Is(err, cmd.ErrUsage) {
+		t.Errorf("unknown command want=ErrUsage got=%v", err)
+	}
+	if err := root.ExecuteContext(context.Background(), []string{"serve", "-port", "1"}); !errors.Is(err, cmd.ErrUsage) {
+		t.Errorf("unknown flag want=ErrUsage got=%v", err)
+	}
+}
+
+func TestCommandHelp(t *testing.T) {
+	var out bytes.Buffer
+	root := tree(&out, &serveConfig{}, new([]string))
+
+	if err := root.ExecuteContext(context.Background(), []string{"help", "serve"}); err != nil {
+		t.Fatal(err)
+	}
+	help := out.String()
+	for _, want := range []string{"Usage: svc serve [flags]", "serve http", "-addr", "listen address", "(default :8081)"} {
+		if !strings.Contains(help, want) {
+			t.Errorf("help should contain %q got=%s", want, help)
+		}
+	}
+
+	out.Reset()
+	if err := root.ExecuteContext(context.Background(), []string{"-h"}); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("-h want=ErrHelp got=%v", err)
+	}
+	if help := out.String(); !strings.Contains(help, "Usage: svc [flags] <command>") || !strings.Contains(help, "migrate") {
+		t.Errorf("root help should list commands got=%s", help)
+	}
+}
+
+func TestCommandCompletion(t *testing.T) {
+	var out bytes.Buffer
+	root := tree(&out, &serveConfig{}, new([]string))
+
+	complete := func(args ...string) string {
+		out.Reset()
+		if err := root.ExecuteContext(context.Background(), append([]string{"__complete"}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(strings.Fields(out.String()), " ")
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{""}, "help completion serve migrate -v"},
+		{[]string{"m"}, "migrate"},
+		{[]string{"-v", "migrate", ""}, "up"},
+		{[]string{"serve", "-"}, "-addr -brokers"},
+	}
+	for _, test := range tests {
+		if got := complete(test.args...); got != test.want {
+			t.Errorf("complete %q want=%q got=%q", test.args, test.want, got)
+		}
+	}
+
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		out.Reset()
+		if err := root.ExecuteContext(context.Background(), []string{"completion", shell}); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "svc __complete") {
+			t.Errorf("%s completion should call back into the command got=%s", shell, out.String())
+		}
+	}
+}
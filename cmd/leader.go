@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/logging"
+)
+
+// Elector grants leadership to a single replica, e.g. repository.AdvisoryElection or repository.LeaseElection.
+// Campaign blocks until leadership is acquired and returns a context cancelled when it is lost.
+// Name identifies the election in the logs.
+type Elector interface {
+	Campaign(ctx context.Context) (context.Context, func() error, error)
+	Name() string
+}
+
+// Leader wraps worker to run only while elector grants leadership, e.g.
+//
+//	cmd.Run(cmd.Leader(election, relay))
+//
+// When leadership is lost the worker context is cancelled and, once the worker returns,
+// the replica campaigns again. Leader returns when ctx is done or the worker returns while leading.
+// Transitions are logged through logging.FromContext with the election attribute.
+func Leader(elector Elector, worker func(context.Context)) func(context.Context) {
+	return func(ctx context.Context) {
+		ctx = logging.With(ctx, slog.String("election", elector.Name()))
+		logger := logging.FromContext(ctx)
+
+		for ctx.Err() == nil {
+			lead, resign, err := elector.Campaign(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+
+				logger.ErrorContext(ctx, "leader campaign failed", slog.String("error", err.Error()))
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+				}
+				continue
+			}
+
+			logger.InfoContext(ctx, "leadership acquired")
+			worker(lead)
+
+			lost := lead.Err() != nil && ctx.Err() == nil
+			if lost {
+				logger.WarnContext(ctx, "leadership lost", slog.Any("error", context.Cause(lead)))
+			}
+			if err := resign(); err != nil {
+				logger.ErrorContext(ctx, "leader resign failed", slog.String("error", err.Error()))
+			}
+			if !lost {
+				return
+			}
+		}
+	}
+}
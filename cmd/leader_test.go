@@ -0,0 +1,63 @@
+package cmd_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/cmd"
+	"github.com/bhmt/tittlemanscrest/logging"
+)
+
+type electorFunc func(ctx context.Context) (context.Context, func() error, error)
+
+func (f electorFunc) Campaign(ctx context.Context) (context.Context, func() error, error) {
+	return f(ctx)
+}
+
+func (f electorFunc) Name() string {
+	return "test"
+}
+
+func TestLeader(t *testing.T) {
+	var campaigns, resigns atomic.Int32
+	elector := electorFunc(func(ctx context.Context) (context.Context, func() error, error) {
+		if campaigns.Add(1) == 1 {
+			return nil, nil, errors.New("database down")
+		}
+
+		lead, cancel := context.WithCancelCause(ctx)
+		if campaigns.Load() == 2 {
+			time.AfterFunc(10*time.Millisecond, func() { cancel(errors.New("lost")) })
+		}
+		return lead, func() error { resigns.Add(1); cancel(nil); return nil }, nil
+	})
+
+	var runs atomic.Int32
+	worker := func(ctx context.Context) {
+		if runs.Add(1) == 1 {
+			<-ctx.Done()
+		}
+	}
+
+	var out bytes.Buffer
+	ctx := logging.WithLogger(context.Background(), slog.New(slog.NewTextHandler(&out, nil)))
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	cmd.Leader(elector, worker)(ctx)
+
+	if ctx.Err() != nil {
+		t.Fatal("leader should return once the worker returns while leading")
+	}
+	if campaigns.Load() != 3 || runs.Load() != 2 || resigns.Load() != 2 {
+		t.Errorf("want 3 campaigns, 2 runs and 2 resigns got=%d %d %d", campaigns.Load(), runs.Load(), resigns.Load())
+	}
+	if !strings.Contains(out.String(), `msg="leadership lost" election=test`) {
+		t.Errorf("leader should log through the context logger got=%s", out.String())
+	}
+}
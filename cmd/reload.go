@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+type reloader struct {
+	name string
+	fn   func(context.Context) error
+}
+
+var reloaders struct {
+	mu      sync.Mutex
+	entries []*reloader
+}
+
+// OnReload registers fn to run when the process receives SIGHUP, after the functions registered before it.
+// Components keep their current state when fn fails, e.g.
+//
+//	cmd.OnReload("flags", store.Reload)
+//	cmd.OnReload("tls", func(ctx context.Context) error { return certs.Reload() })
+//
+// The returned function unregisters fn, e.g. when a component is closed before the process exits.
+func OnReload(name string, fn func(ctx context.Context) error) func() {
+	r := &reloader{name: name, fn: fn}
+
+	reloaders.mu.Lock()
+	defer reloaders.mu.Unlock()
+	reloaders.entries = append(reloaders.entries, r)
+
+	return func() {
+		reloaders.mu.Lock()
+		defer reloaders.mu.Unlock()
+		// A running Reload keeps iterating the previous slice.
+		reloaders.entries = slices.DeleteFunc(slices.Clone(reloaders.entries), func(e *reloader) bool { return e == r })
+	}
+}
+
+// Reload runs every registered function, a failure does not stop the ones after it.
+// The returned error joins the failures prefixed with their names.
+func Reload(ctx context.Context) error {
+	reloaders.mu.Lock()
+	entries := reloaders.entries
+	reloaders.mu.Unlock()
+
+	var errs []error
+	for _, r := range entries {
+		if err := r.fn(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func reload(ctx context.Context) {
+	if err := Reload(ctx); err != nil {
+		slog.ErrorContext(ctx, "reload failed", slog.String("error", err.Error()))
+		return
+	}
+	slog.InfoContext(ctx, "reloaded")
+}
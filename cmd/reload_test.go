@@ -0,0 +1,60 @@
+//go:build !windows
+
+package cmd_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/cmd"
+)
+
+func TestRunReload(t *testing.T) {
+	reloaded := make(chan string, 2)
+	unregister := cmd.OnReload("failing", func(ctx context.Context) error {
+		reloaded <- "failing"
+		return errors.New("antigravity")
+	})
+	defer cmd.OnReload("flags", func(ctx context.Context) error {
+		reloaded <- "flags"
+		return nil
+	})()
+
+	if err := cmd.Reload(context.Background()); err == nil || err.Error() != "failing: antigravity" {
+		t.Errorf("reload error want=%q got=%v", "failing: antigravity", err)
+	}
+	<-reloaded
+	<-reloaded
+
+	cmd.Run(func(ctx context.Context) {
+		syscall.Kill(os.Getpid(), syscall.SIGHUP)
+
+		for _, want := range []string{"failing", "flags"} {
+			select {
+			case got := <-reloaded:
+				if got != want {
+					t.Errorf("reload order want=%s got=%s", want, got)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("reload %s not run on SIGHUP", want)
+			}
+		}
+
+		if ctx.Err() != nil {
+			t.Error("SIGHUP should not cancel the worker context")
+		}
+	})
+
+	unregister()
+	unregister()
+	if err := cmd.Reload(context.Background()); err != nil {
+		t.Errorf("unregistered reload should not run got=%v", err)
+	}
+	if got := <-reloaded; got != "flags" {
+		t.Errorf("reload after unregister want=flags got=%s", got)
+	}
+}
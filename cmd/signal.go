@@ -7,6 +7,8 @@ import (
 	"syscall"
 )
 
+// Run calls worker with a context cancelled on SIGINT or SIGTERM.
+// SIGHUP runs the functions registered with OnReload without cancelling the context.
 func Run(worker func(context.Context)) {
 	ctx, cancel := signal.NotifyContext(
 		context.Background(),
@@ -14,5 +16,21 @@ func Run(worker func(context.Context)) {
 		syscall.SIGTERM,
 	)
 	defer cancel()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				reload(ctx)
+			}
+		}
+	}()
+
 	worker(ctx)
 }
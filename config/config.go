@@ -0,0 +1,347 @@
+package config
+
+import (
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Errors lists every problem found while loading a configuration.
+type Errors []error
+
+func (e Errors) Error() string {
+	problems := make([]string, len(e))
+	for i, err := range e {
+		problems[i] = err.Error()
+	}
+	return "invalid configuration:\n  " + strings.Join(problems, "\n  ")
+}
+
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// Validator is implemented by configurations checking more than required fields.
+type Validator interface {
+	Validate() error
+}
+
+// Loader configures the sources read by Load.
+type Loader struct {
+	files  []string
+	prefix string
+	fs     *flag.FlagSet
+	args   []string
+	env    func(string) (string, bool)
+}
+
+// WithFiles reads the YAML files in order, later files override earlier ones.
+// Missing files are skipped.
+func WithFiles(names ...string) func(*Loader) {
+	return func(l *Loader) {
+		l.files = append(l.files, names...)
+	}
+}
+
+// WithEnvPrefix prefixes every env tag, e.g. APP_ for `env:"PORT"` reads APP_PORT.
+func WithEnvPrefix(prefix string) func(*Loader) {
+	return func(l *Loader) {
+		l.prefix = prefix
+	}
+}
+
+// WithEnv replaces os.LookupEnv.
+func WithEnv(lookup func(string) (string, bool)) func(*Loader) {
+	return func(l *Loader) {
+		l.env = lookup
+	}
+}
+
+// WithFlags applies the flags set on fs. A flag set that is not parsed yet
+// gets the flags of the configuration registered and is parsed with args.
+func WithFlags(fs *flag.FlagSet, args []string) func(*Loader) {
+	return func(l *Loader) {
+		l.fs = fs
+		l.args = args
+	}
+}
+
+type field struct {
+	path  string
+	value reflect.Value
+	tag   reflect.StructTag
+}
+
+// fields walks the struct fields of cfg, nested structs included.
+func fields(v reflect.Value, prefix string, visit func(field)) {
+	t := v.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		fv := v.Field(i)
+		path := prefix + f.Name
+		if f.Type.Kind() == reflect.Struct && !isValue(fv) {
+			fields(fv, path+".", visit)
+			continue
+		}
+		visit(field{path: path, value: fv, tag: f.Tag})
+	}
+}
+
+// isValue reports whether a struct is set from a single string, e.g. time.Time.
+func isValue(v reflect.Value) bool {
+	_, ok := v.Addr().Interface().(encoding.TextUnmarshaler)
+	return ok
+}
+
+// Load populates cfg, a pointer to a struct, from the sources in order:
+// `default` tags, YAML files, `env` variables and `flag` flags.
+// Variables named with a _FILE suffix are read from the file they point to, e.g. DB_PASSWORD_FILE.
+// Fields tagged `required:"true"` must be set by a source.
+// All problems are returned together as Errors.
+func Load(cfg any, opts ...func(*Loader)) error {
+	l := Loader{env: os.LookupEnv}
+	for _, o := range opts {
+		o(&l)
+	}
+
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: want pointer to struct got %T", cfg)
+	}
+
+	var errs Errors
+	fields(v.Elem(), "", func(f field) {
+		if def, ok := f.tag.Lookup("default"); ok {
+			if err := setValue(f.value, def); err != nil {
+				errs = append(errs, fmt.Errorf("%s: default: %w", f.path, err))
+			}
+		}
+	})
+
+	for _, name := range l.files {
+		data, err := os.ReadFile(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err == nil {
+			err = yaml.Unmarshal(data, cfg)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	set := map[string]bool{}
+	fields(v.Elem(), "", func(f field) {
+		name, ok := f.tag.Lookup("env")
+		if !ok {
+			return
+		}
+
+		name = l.prefix + name
+		value, ok, err := l.lookup(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.path, err))
+			return
+		}
+		if !ok {
+			return
+		}
+
+		if err := setValue(f.value, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: env %s: %w", f.path, name, err))
+			return
+		}
+		set[f.path] = true
+	})
+
+	if l.fs != nil {
+		if !l.fs.Parsed() {
+			if err := RegisterFlags(l.fs, cfg); err != nil {
+				return err
+			}
+			if err := l.fs.Parse(l.args); err != nil {
+				return err
+			}
+		}
+
+		visited := map[string]string{}
+		l.fs.Visit(func(f *flag.Flag) { visited[f.Name] = f.Value.String() })
+
+		fields(v.Elem(), "", func(f field) {
+			name := f.tag.Get("flag")
+			value, ok := visited[name]
+			if name == "" || !ok {
+				return
+			}
+
+			if err := setValue(f.value, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: flag -%s: %w", f.path, name, err))
+				return
+			}
+			set[f.path] = true
+		})
+	}
+
+	fields(v.Elem(), "", func(f field) {
+		if f.tag.Get("required") == "true" && f.value.IsZero() && !set[f.path] {
+			errs = append(errs, fmt.Errorf("%s: required", f.path))
+		}
+	})
+
+	if validator, ok := cfg.(Validator); ok && len(errs) == 0 {
+		if err := validator.Validate(); err != nil {
+			var list Errors
+			if errors.As(err, &list) {
+				errs = append(errs, list...)
+			} else {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// lookup reads the variable, or the file named by the variable with a _FILE suffix.
+func (l *Loader) lookup(name string) (string, bool, error) {
+	if value, ok := l.env(name); ok {
+		return value, true, nil
+	}
+
+	file, ok := l.env(name + "_FILE")
+	if !ok {
+		return "", false, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", false, fmt.Errorf("env %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+// RegisterFlags defines a flag for every field with a `flag` tag,
+// using the `usage` tag and showing the `default` tag.
+func RegisterFlags(fs *flag.FlagSet, cfg any) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: want pointer to struct got %T", cfg)
+	}
+
+	fields(v.Elem(), "", func(f field) {
+		name := f.tag.Get("flag")
+		if name == "" || fs.Lookup(name) != nil {
+			return
+		}
+
+		value := &flagValue{value: f.tag.Get("default"), bool: f.value.Kind() == reflect.Bool}
+		fs.Var(value, name, f.tag.Get("usage"))
+		fs.Lookup(name).DefValue = f.tag.Get("default")
+	})
+	return nil
+}
+
+// flagValue keeps the flag text, it is applied to the configuration by Load.
+type flagValue struct {
+	value string
+	bool  bool
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *flagValue) Set(s string) error {
+	f.value = s
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.bool
+}
+
+// setValue parses s into v, slices are comma separated.
+func setValue(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == reflect.TypeFor[time.Duration]() {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+
+		n, err := strconv.ParseInt(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+
+	case reflect.Slice:
+		var parts []string
+		if s != "" {
+			parts = strings.Split(s, ",")
+		}
+
+		slice := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setValue(slice.Index(i), strings.TrimSpace(part)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
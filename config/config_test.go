@@ -0,0 +1,113 @@
+package config_test
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/config"
+)
+
+type testConfig struct {
+	Addr     string        `yaml:"addr" env:"ADDR" flag:"addr" default:":8081" usage:"listen address"`
+	Timeout  time.Duration `yaml:"timeout" env:"TIMEOUT" default:"5s"`
+	MaxBody  config.Size   `yaml:"max_body" env:"MAX_BODY" default:"1MiB"`
+	Brokers  []string      `yaml:"brokers" env:"BROKERS" flag:"brokers" required:"true"`
+	Verbose  bool          `yaml:"verbose" flag:"v"`
+	Database struct {
+		Dsn      string `yaml:"dsn" env:"DB_DSN" required:"true" secret:"true"`
+		Password string `yaml:"password" env:"DB_PASSWORD" secret:"true"`
+	} `yaml:"database"`
+}
+
+func env(vars map[string]string) func(*config.Loader) {
+	return config.WithEnv(func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	})
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	os.WriteFile(file, []byte("addr: :9000\ntimeout: 10s\nmax_body: 2MB\nbrokers: [yaml:9092]\ndatabase:\n  dsn: postgres://yaml\n"), 0o600)
+
+	secret := filepath.Join(dir, "password")
+	os.WriteFile(secret, []byte("antigravity\n"), 0o600)
+
+	var cfg testConfig
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := config.Load(&cfg,
+		config.WithFiles(file, filepath.Join(dir, "missing.yaml")),
+		config.WithEnvPrefix("APP_"),
+		env(map[string]string{"APP_TIMEOUT": "30s", "APP_BROKERS": "env:9092, env:9093", "APP_DB_PASSWORD_FILE": secret}),
+		config.WithFlags(fs, []string{"-addr", ":7000", "-v"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Addr != ":7000" || !cfg.Verbose {
+		t.Errorf("flags should override, got addr=%s verbose=%v", cfg.Addr, cfg.Verbose)
+	}
+	if cfg.Timeout != 30*time.Second || strings.Join(cfg.Brokers, ",") != "env:9092,env:9093" {
+		t.Errorf("env should override yaml, got timeout=%s brokers=%v", cfg.Timeout, cfg.Brokers)
+	}
+	if cfg.MaxBody != 2_000_000 || cfg.Database.Dsn != "postgres://yaml" {
+		t.Errorf("yaml should override defaults, got max_body=%d dsn=%s", cfg.MaxBody, cfg.Database.Dsn)
+	}
+	if cfg.Database.Password != "antigravity" {
+		t.Errorf("_FILE secret want=antigravity got=%q", cfg.Database.Password)
+	}
+
+	printed := config.String(cfg)
+	if strings.Contains(printed, "antigravity") || strings.Contains(printed, "postgres://yaml") || !strings.Contains(printed, `"max_body": "2000000B"`) {
+		t.Errorf("secrets should be redacted, got=%s", printed)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	var cfg testConfig
+	err := config.Load(&cfg, env(map[string]string{"TIMEOUT": "soon", "MAX_BODY": "lots"}))
+
+	var errs config.Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("want config.Errors got=%v", err)
+	}
+
+	for _, want := range []string{"Timeout: env TIMEOUT", "MaxBody: env MAX_BODY", "Brokers: required", "Database.Dsn: required"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("errors should list %q got=%s", want, err)
+		}
+	}
+	if len(errs) != 4 {
+		t.Errorf("want 4 problems got=%d", len(errs))
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		text string
+		want config.Size
+	}{
+		{"512", 512},
+		{"64KB", 64_000},
+		{"10MiB", 10 << 20},
+		{"1.5g", 3 << 29},
+		{"2 TiB", 2 << 40},
+	}
+
+	for _, test := range tests {
+		if got, err := config.ParseSize(test.text); err != nil || got != test.want {
+			t.Errorf("size %q want=%d got=%d err=%v", test.text, test.want, got, err)
+		}
+	}
+
+	if _, err := config.ParseSize("-1k"); err == nil {
+		t.Error("negative size should fail")
+	}
+}
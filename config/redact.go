@@ -0,0 +1,112 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+// Redact converts v to plain maps and slices for printing, replacing fields tagged `secret:"true"`.
+// Struct keys follow the json tag names, then the yaml tag names.
+func Redact(v any) any {
+	return redact(reflect.ValueOf(v))
+}
+
+func redact(v reflect.Value) any {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if _, ok := v.Interface().(time.Time); ok {
+			return v.Interface()
+		}
+
+		out := map[string]any{}
+		t := v.Type()
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+
+			name, skip := fieldName(f)
+			if skip {
+				continue
+			}
+
+			if f.Tag.Get("secret") == "true" {
+				out[name] = redacted
+				continue
+			}
+
+			out[name] = redact(v.Field(i))
+		}
+		return out
+
+	case reflect.Map:
+		out := map[string]any{}
+		iter := v.MapRange()
+		for iter.Next() {
+			out[mapKey(iter.Key())] = redact(iter.Value())
+		}
+		return out
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		out := make([]any, v.Len())
+		for i := range v.Len() {
+			out[i] = redact(v.Index(i))
+		}
+		return out
+
+	case reflect.Invalid:
+		return nil
+
+	default:
+		if d, ok := v.Interface().(time.Duration); ok {
+			return d.String()
+		}
+		return v.Interface()
+	}
+}
+
+func mapKey(v reflect.Value) string {
+	if s, ok := v.Interface().(interface{ String() string }); ok {
+		return s.String()
+	}
+	if v.Kind() == reflect.String {
+		return v.String()
+	}
+	b, _ := json.Marshal(v.Interface())
+	return string(b)
+}
+
+func fieldName(f reflect.StructField) (string, bool) {
+	for _, key := range []string{"json", "yaml"} {
+		if tag, _, _ := strings.Cut(f.Tag.Get(key), ","); tag == "-" {
+			return "", true
+		} else if tag != "" {
+			return tag, false
+		}
+	}
+	return f.Name, false
+}
+
+// String formats v as indented JSON with secrets redacted.
+func String(v any) string {
+	data, err := json.MarshalIndent(Redact(v), "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
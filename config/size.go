@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Size is a number of bytes parsed from text such as 512, 64KB, 10MiB or 1.5G.
+// KB, MB, GB and TB are powers of 1000, KiB, MiB, GiB and TiB and the single letters are powers of 1024.
+type Size int64
+
+var sizeUnits = []struct {
+	suffix string
+	scale  float64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30}, {"tib", 1 << 40},
+	{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9}, {"tb", 1e12},
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30}, {"t", 1 << 40},
+	{"b", 1},
+}
+
+func ParseSize(s string) (Size, error) {
+	text := strings.ToLower(strings.TrimSpace(s))
+
+	scale := 1.0
+	for _, unit := range sizeUnits {
+		if number, ok := strings.CutSuffix(text, unit.suffix); ok {
+			text, scale = strings.TrimSpace(number), unit.scale
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(text, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return Size(n * scale), nil
+}
+
+func (s *Size) UnmarshalText(text []byte) error {
+	size, err := ParseSize(string(text))
+	if err != nil {
+		return err
+	}
+	*s = size
+	return nil
+}
+
+func (s Size) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// String formats the size with the largest binary unit dividing it.
+func (s Size) String() string {
+	for _, unit := range []struct {
+		suffix string
+		scale  Size
+	}{{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}} {
+		if s != 0 && s%unit.scale == 0 {
+			return strconv.FormatInt(int64(s/unit.scale), 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(int64(s), 10) + "B"
+}
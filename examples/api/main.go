@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
@@ -9,15 +11,30 @@ import (
 	"github.com/bhmt/tittlemanscrest/api"
 	"github.com/bhmt/tittlemanscrest/api/handlers"
 	"github.com/bhmt/tittlemanscrest/cmd"
+	"github.com/bhmt/tittlemanscrest/config"
+	"github.com/bhmt/tittlemanscrest/logging"
 )
 
-func Work(ctx context.Context) {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil)).With(slog.String("app_id", "example"))
+type Config struct {
+	Addr      string         `yaml:"addr" env:"ADDR" flag:"addr" default:":8081" usage:"api listen address"`
+	AdminAddr string         `yaml:"admin_addr" env:"ADMIN_ADDR" flag:"admin-addr" default:"localhost:6060" usage:"admin listen address"`
+	Log       logging.Config `yaml:"log"`
+}
+
+func load(cfg *Config) error {
+	cfg.Log.Attrs = []string{"app_id=example"}
+	return config.Load(cfg, config.WithFiles("config.yaml"), config.WithEnvPrefix("EXAMPLE_"), config.WithFlags(flag.CommandLine, os.Args[1:]))
+}
 
+func Work(ctx context.Context, cfg Config, logger *slog.Logger, level *slog.LevelVar) {
+	chaos := api.NewChaos()
 	handler := api.MiddlewareBase(
 		logger,
-		api.MiddlewareRest(
-			http.HandlerFunc(handlers.Health()),
+		api.MiddlewareChaos(
+			chaos,
+			api.MiddlewareRest(
+				http.HandlerFunc(handlers.Health()),
+			),
 		),
 	)
 
@@ -27,13 +44,41 @@ func Work(ctx context.Context) {
 		handler,
 	)
 
-	server := api.New(":8081", mux)
+	server := api.New(cfg.Addr, mux, api.WithErrorLogger(logger))
 	go func() { server.ListenAndServe() }()
-	logger.InfoContext(ctx, "listening on :8081")
+	logger.InfoContext(ctx, "listening", slog.String("addr", cfg.Addr))
+
+	adminHandler := api.NewAdmin(level, cfg)
+	adminHandler.Handle("/debug/chaos", chaos)
+	admin := api.New(cfg.AdminAddr, adminHandler)
+	go func() { admin.ListenAndServe() }()
+	logger.InfoContext(ctx, "admin listening", slog.String("addr", cfg.AdminAddr))
+
+	cmd.OnReload("log level", func(ctx context.Context) error {
+		var reloaded Config
+		if err := load(&reloaded); err != nil {
+			return err
+		}
+		level.Set(reloaded.Log.Level)
+		return nil
+	})
 
 	<-ctx.Done()
 }
 
 func main() {
-	cmd.Run(Work)
+	var cfg Config
+	if err := load(&cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger, level, err := logging.New(cfg.Log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
+	cmd.Run(func(ctx context.Context) { Work(ctx, cfg, logger, level) })
 }
@@ -2,17 +2,25 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand/v2"
+	"os"
 	"time"
 
 	"github.com/bhmt/tittlemanscrest/cmd"
+	"github.com/bhmt/tittlemanscrest/config"
 	"github.com/bhmt/tittlemanscrest/kafka"
+	"github.com/bhmt/tittlemanscrest/logging"
 )
 
-var brokers = []string{"localhost:49092"}
-var topic = "items"
+type Config struct {
+	Brokers []string       `yaml:"brokers" env:"KAFKA_BROKERS" flag:"brokers" default:"localhost:49092" usage:"comma separated kafka brokers"`
+	Topic   string         `yaml:"topic" env:"KAFKA_TOPIC" flag:"topic" default:"items" usage:"kafka topic"`
+	Group   string         `yaml:"group" env:"KAFKA_GROUP" flag:"group" default:"item-service-group" usage:"consumer group id"`
+	Log     logging.Config `yaml:"log"`
+}
 
 type Item struct {
 	Id        int    `json:"id"`
@@ -20,10 +28,10 @@ type Item struct {
 	Value     string `json:"value"`
 }
 
-func produce(ctx context.Context) {
-	prod, err := kafka.NewProducer(brokers, topic, kafka.JsonSerializer[Item]{}, nil)
+func produce(ctx context.Context, cfg Config) {
+	prod, err := kafka.NewProducer(cfg.Brokers, cfg.Topic, kafka.JsonSerializer[Item]{}, nil)
 	if err != nil {
-		log.Printf("new producer error: %v", err)
+		logging.FromContext(ctx).ErrorContext(ctx, "new producer", slog.String("error", err.Error()))
 		return
 	}
 
@@ -45,7 +53,7 @@ func produce(ctx context.Context) {
 			}
 
 			if err := prod.Publish(ctx, key, item); err != nil {
-				log.Printf("producer error: %v", err)
+				logging.FromContext(ctx).ErrorContext(ctx, "publish", slog.String("error", err.Error()))
 				return
 			}
 			i++
@@ -53,25 +61,42 @@ func produce(ctx context.Context) {
 	}
 }
 
-func consume(ctx context.Context) {
-	cons := kafka.NewConsumer(brokers, topic, "item-service-group", kafka.JsonSerializer[Item]{}, nil)
+func consume(ctx context.Context, cfg Config) {
+	cons, err := kafka.NewConsumer(cfg.Brokers, cfg.Topic, cfg.Group, kafka.JsonSerializer[Item]{}, nil, kafka.WithLogger[Item](logging.FromContext(ctx)))
+	if err != nil {
+		logging.FromContext(ctx).ErrorContext(ctx, "new consumer", slog.String("error", err.Error()))
+		return
+	}
 	defer cons.Close()
 
 	handler := func(ctx context.Context, msg Item) error {
-		log.Printf("item %d %d %s", msg.Id, msg.Timestamp, msg.Value)
+		logging.FromContext(ctx).InfoContext(ctx, "item", slog.Int("id", msg.Id), slog.Int64("ts", msg.Timestamp), slog.String("value", msg.Value))
 		return nil
 	}
 
 	if err := cons.Consume(ctx, handler); err != nil {
-		log.Printf("consumer error: %v", err)
+		logging.FromContext(ctx).ErrorContext(ctx, "consume", slog.String("error", err.Error()))
 		return
 	}
 }
 
 func main() {
+	var cfg Config
+	if err := config.Load(&cfg, config.WithFiles("config.yaml"), config.WithFlags(flag.CommandLine, os.Args[1:])); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger, _, err := logging.New(cfg.Log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	cmd.Run(func(ctx context.Context) {
-		go produce(ctx)
-		go consume(ctx)
+		ctx = logging.WithLogger(ctx, logger)
+		go produce(ctx, cfg)
+		go consume(ctx, cfg)
 		<-ctx.Done()
 	})
 }
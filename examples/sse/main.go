@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log/slog"
 	"math/rand/v2"
@@ -12,6 +13,8 @@ import (
 	"github.com/bhmt/tittlemanscrest/api"
 	"github.com/bhmt/tittlemanscrest/api/handlers"
 	"github.com/bhmt/tittlemanscrest/cmd"
+	"github.com/bhmt/tittlemanscrest/config"
+	"github.com/bhmt/tittlemanscrest/logging"
 )
 
 func events() <-chan []byte {
@@ -29,8 +32,12 @@ func events() <-chan []byte {
 	return out
 }
 
-func Work(ctx context.Context) {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil)).With(slog.String("app_id", "sse"))
+type Config struct {
+	Addr string         `env:"ADDR" flag:"addr" default:":8081" usage:"listen address"`
+	Log  logging.Config `yaml:"log"`
+}
+
+func Work(ctx context.Context, cfg Config, logger *slog.Logger) {
 	liveliness := 15 * time.Second
 
 	mux := http.NewServeMux()
@@ -42,13 +49,25 @@ func Work(ctx context.Context) {
 		),
 	)
 
-	server := api.New(":8081", mux)
+	server := api.New(cfg.Addr, mux)
 	go func() { server.ListenAndServe() }()
-	logger.InfoContext(ctx, "listening on :8081")
+	logger.InfoContext(ctx, "listening", slog.String("addr", cfg.Addr))
 
 	<-ctx.Done()
 }
 
 func main() {
-	cmd.Run(Work)
+	cfg := Config{Log: logging.Config{Attrs: []string{"app_id=sse"}}}
+	if err := config.Load(&cfg, config.WithFlags(flag.CommandLine, os.Args[1:])); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger, _, err := logging.New(cfg.Log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	cmd.Run(func(ctx context.Context) { Work(ctx, cfg, logger) })
 }
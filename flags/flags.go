@@ -0,0 +1,257 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bhmt/tittlemanscrest/repository"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidFlag = fmt.Errorf("invalid feature flag")
+
+// Flag is a feature toggle.
+// A disabled flag is always off. An enabled flag without targeting or percentage is on for everyone,
+// otherwise it is on for the targeted subjects and attributes and for Percentage of the other subjects.
+// Attrs keys are resolved by the Target, e.g. "header:X-Beta" or "tenant".
+type Flag struct {
+	Name       string              `json:"name" yaml:"name"`
+	Enabled    bool                `json:"enabled" yaml:"enabled"`
+	Percentage int                 `json:"percentage,omitempty" yaml:"percentage,omitempty"`
+	Subjects   []string            `json:"subjects,omitempty" yaml:"subjects,omitempty"`
+	Attrs      map[string][]string `json:"attrs,omitempty" yaml:"attrs,omitempty"`
+}
+
+// Target is who a flag is evaluated for.
+// Key buckets percentage rollouts when Subject is empty, e.g. a session id or the client ip.
+// Targets without either share a single bucket, they are all on or all off.
+// Lookup resolves the attribute keys of targeted flags, it may be nil.
+type Target struct {
+	Subject string
+	Key     string
+	Lookup  func(key string) string
+}
+
+func (f Flag) Validate() error {
+	if f.Name == "" {
+		return fmt.Errorf("%w: missing name", ErrInvalidFlag)
+	}
+	if f.Percentage < 0 || f.Percentage > 100 {
+		return fmt.Errorf("%w: %s percentage %d out of range", ErrInvalidFlag, f.Name, f.Percentage)
+	}
+	return nil
+}
+
+// On evaluates the flag for the target.
+// Percentage rollouts bucket subjects by a hash of the flag name and subject, or key,
+// so a subject keeps its bucket while the percentage grows.
+func (f Flag) On(t Target) bool {
+	if !f.Enabled {
+		return false
+	}
+
+	if f.Percentage == 0 && len(f.Subjects) == 0 && len(f.Attrs) == 0 {
+		return true
+	}
+
+	if t.Subject != "" && slices.Contains(f.Subjects, t.Subject) {
+		return true
+	}
+
+	if t.Lookup != nil {
+		for key, values := range f.Attrs {
+			if v := t.Lookup(key); v != "" && slices.Contains(values, v) {
+				return true
+			}
+		}
+	}
+
+	if f.Percentage == 0 {
+		return false
+	}
+
+	bucket := t.Subject
+	if bucket == "" {
+		bucket = t.Key
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(f.Name + ":" + bucket))
+	return int(h.Sum32()%100) < f.Percentage
+}
+
+// Source loads the flags of a Store.
+type Source interface {
+	Load(ctx context.Context) ([]Flag, error)
+}
+
+type SourceFunc func(ctx context.Context) ([]Flag, error)
+
+func (f SourceFunc) Load(ctx context.Context) ([]Flag, error) {
+	return f(ctx)
+}
+
+// Memory is a source of fixed flags, runtime changes are made on the Store.
+func Memory(flags ...Flag) Source {
+	return SourceFunc(func(ctx context.Context) ([]Flag, error) {
+		return flags, nil
+	})
+}
+
+// File reads a YAML or JSON list of flags on every load.
+func File(name string) Source {
+	return SourceFunc(func(ctx context.Context) ([]Flag, error) {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		var flags []Flag
+		if err := yaml.Unmarshal(data, &flags); err != nil {
+			return nil, errors.Join(ErrInvalidFlag, err)
+		}
+		return flags, nil
+	})
+}
+
+// SQL reads and writes flags in the feature_flag table, one JSON definition per name.
+type SQL struct {
+	session *repository.Session
+}
+
+func NewSQL(session *repository.Session) *SQL {
+	return &SQL{session: session}
+}
+
+func (s *SQL) Load(ctx context.Context) ([]Flag, error) {
+	rows, err := s.session.QueryContext(ctx, `select definition from feature_flag order by name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flags []Flag
+	for rows.Next() {
+		var definition string
+		if err := rows.Scan(&definition); err != nil {
+			return nil, err
+		}
+
+		var f Flag
+		if err := json.Unmarshal([]byte(definition), &f); err != nil {
+			return nil, errors.Join(ErrInvalidFlag, err)
+		}
+		flags = append(flags, f)
+	}
+	return flags, rows.Err()
+}
+
+// Save stores the flag, other processes see it after their next Reload.
+func (s *SQL) Save(ctx context.Context, f Flag) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+
+	definition, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	query := `insert into feature_flag (name, definition) values ($1, $2)
+on conflict (name) do update set definition = excluded.definition`
+	_, err = s.session.ExecContext(ctx, query, f.Name, string(definition))
+	return err
+}
+
+func (s *SQL) Delete(ctx context.Context, name string) error {
+	_, err := s.session.ExecContext(ctx, `delete from feature_flag where name = $1`, name)
+	return err
+}
+
+// Store holds the flags loaded from a source and the changes made at runtime.
+// Unknown flags are off.
+type Store struct {
+	source Source
+
+	mu    sync.RWMutex
+	flags map[string]Flag
+}
+
+func NewStore(ctx context.Context, source Source) (*Store, error) {
+	s := Store{source: source}
+	if err := s.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Reload replaces the flags with a fresh load from the source, discarding runtime changes.
+// The current flags are kept when loading fails.
+func (s *Store) Reload(ctx context.Context) error {
+	loaded, err := s.source.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	flags := make(map[string]Flag, len(loaded))
+	var errs []error
+	for _, f := range loaded {
+		if err := f.Validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		flags[f.Name] = f
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flags = flags
+	return nil
+}
+
+// Put adds or replaces a flag until the next Reload.
+func (s *Store) Put(f Flag) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flags[f.Name] = f
+	return nil
+}
+
+func (s *Store) Get(name string) (Flag, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, ok := s.flags[name]
+	return f, ok
+}
+
+// All returns the flags sorted by name.
+func (s *Store) All() []Flag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := make([]Flag, 0, len(s.flags))
+	for _, f := range s.flags {
+		all = append(all, f)
+	}
+	slices.SortFunc(all, func(a, b Flag) int { return strings.Compare(a.Name, b.Name) })
+	return all
+}
+
+func (s *Store) On(name string, t Target) bool {
+	f, ok := s.Get(name)
+	return ok && f.On(t)
+}
@@ -0,0 +1,120 @@
+package flags_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bhmt/tittlemanscrest/flags"
+	"github.com/bhmt/tittlemanscrest/repository"
+	_ "modernc.org/sqlite"
+)
+
+func TestFlagOn(t *testing.T) {
+	beta := func(key string) string {
+		if key == "header:X-Beta" {
+			return "1"
+		}
+		return ""
+	}
+
+	tests := []struct {
+		name   string
+		flag   flags.Flag
+		target flags.Target
+		want   bool
+	}{
+		{"disabled", flags.Flag{Name: "a"}, flags.Target{}, false},
+		{"boolean", flags.Flag{Name: "a", Enabled: true}, flags.Target{}, true},
+		{"subject", flags.Flag{Name: "a", Enabled: true, Subjects: []string{"jon"}}, flags.Target{Subject: "jon"}, true},
+		{"not targeted", flags.Flag{Name: "a", Enabled: true, Subjects: []string{"jon"}}, flags.Target{Subject: "daker"}, false},
+		{"attribute", flags.Flag{Name: "a", Enabled: true, Attrs: map[string][]string{"header:X-Beta": {"1"}}}, flags.Target{Lookup: beta}, true},
+		{"full rollout", flags.Flag{Name: "a", Enabled: true, Percentage: 100}, flags.Target{Subject: "napoli"}, true},
+	}
+
+	for _, test := range tests {
+		if got := test.flag.On(test.target); got != test.want {
+			t.Errorf("%s want=%v got=%v", test.name, test.want, got)
+		}
+	}
+
+	rollout := flags.Flag{Name: "rollout", Enabled: true, Percentage: 30}
+	on := 0
+	for i := range 1000 {
+		if rollout.On(flags.Target{Subject: fmt.Sprint(i)}) {
+			on++
+		}
+	}
+	if on < 250 || on > 350 {
+		t.Errorf("percentage rollout want about 300 of 1000 got=%d", on)
+	}
+
+	on = 0
+	for i := range 1000 {
+		if rollout.On(flags.Target{Key: fmt.Sprint(i)}) {
+			on++
+		}
+	}
+	if on < 250 || on > 350 {
+		t.Errorf("anonymous rollout by key want about 300 of 1000 got=%d", on)
+	}
+}
+
+func TestStoreFileReload(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "flags.yaml")
+	os.WriteFile(name, []byte("- {name: search, enabled: true}\n"), 0o600)
+
+	store, err := flags.NewStore(context.Background(), flags.File(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store.On("search", flags.Target{}) || store.On("missing", flags.Target{}) {
+		t.Error("file flags not loaded")
+	}
+
+	store.Put(flags.Flag{Name: "search"})
+	if store.On("search", flags.Target{}) {
+		t.Error("runtime toggle not applied")
+	}
+
+	os.WriteFile(name, []byte("- {name: search, enabled: true}\n- {name: bad, percentage: 120}\n"), 0o600)
+	if err := store.Reload(context.Background()); !errors.Is(err, flags.ErrInvalidFlag) {
+		t.Errorf("invalid reload want=%v got=%v", flags.ErrInvalidFlag, err)
+	}
+	if store.On("search", flags.Target{}) {
+		t.Error("failed reload should keep the current flags")
+	}
+}
+
+func TestStoreSQL(t *testing.T) {
+	session, err := repository.NewSession("sqlite", "file:flags?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	ctx := context.Background()
+	if _, err := session.ExecContext(ctx, `create table feature_flag (name text primary key, definition text not null)`); err != nil {
+		t.Fatal(err)
+	}
+
+	source := flags.NewSQL(session)
+	source.Save(ctx, flags.Flag{Name: "checkout", Enabled: true, Subjects: []string{"jon"}})
+
+	store, err := flags.NewStore(ctx, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store.On("checkout", flags.Target{Subject: "jon"}) || store.On("checkout", flags.Target{Subject: "daker"}) {
+		t.Error("sql flag not loaded")
+	}
+
+	source.Save(ctx, flags.Flag{Name: "checkout", Enabled: true})
+	store.Reload(ctx)
+	if !store.On("checkout", flags.Target{Subject: "daker"}) {
+		t.Error("sql flag update not reloaded")
+	}
+}
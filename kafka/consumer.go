@@ -4,9 +4,11 @@ import (
 	"context"
 	"errors"
 	"io"
-	"log"
+	"log/slog"
+	"strings"
 	"time"
 
+	"github.com/bhmt/tittlemanscrest/logging"
 	kgo "github.com/segmentio/kafka-go"
 )
 
@@ -21,6 +23,7 @@ type Consumer[T any] struct {
 	serializer Serializer[T]
 	backoffMin time.Duration
 	backoffMax time.Duration
+	logger     *slog.Logger
 }
 
 func WithBackoffMin[T any](n time.Duration) func(*Consumer[T]) error {
@@ -37,9 +40,18 @@ func WithBackoffMax[T any](n time.Duration) func(*Consumer[T]) error {
 	}
 }
 
+// WithLogger sets the logger used for failed messages and passed to handlers, slog.Default by default.
+// Its level can be changed at runtime through the handler's slog.LevelVar.
+func WithLogger[T any](logger *slog.Logger) func(*Consumer[T]) error {
+	return func(c *Consumer[T]) error {
+		c.logger = logger
+		return nil
+	}
+}
+
 type HandlerFunc[T any] func(ctx context.Context, msg T) error
 
-func NewConsumer[T any](brokers []string, topic, groupID string, serializer Serializer[T], config *kgo.ReaderConfig) *Consumer[T] {
+func NewConsumer[T any](brokers []string, topic, groupID string, serializer Serializer[T], config *kgo.ReaderConfig, opts ...func(*Consumer[T]) error) (*Consumer[T], error) {
 	readerConfig := DefaultReaderConfig
 
 	if config != nil {
@@ -50,12 +62,21 @@ func NewConsumer[T any](brokers []string, topic, groupID string, serializer Seri
 	readerConfig.Topic = topic
 	readerConfig.GroupID = groupID
 
-	return &Consumer[T]{
-		reader:     kgo.NewReader(readerConfig),
+	c := &Consumer[T]{
 		serializer: serializer,
 		backoffMin: 100 * time.Millisecond,
 		backoffMax: 1 * time.Second,
+		logger:     slog.Default(),
+	}
+
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
 	}
+
+	c.reader = kgo.NewReader(readerConfig)
+	return c, nil
 }
 
 func (c *Consumer[T]) Consume(ctx context.Context, handler HandlerFunc[T]) error {
@@ -88,21 +109,24 @@ func (c *Consumer[T]) Consume(ctx context.Context, handler HandlerFunc[T]) error
 
 		currentBackoff = c.backoffMin
 
+		logger := c.messageLogger(m)
+		msgCtx := logging.WithLogger(ctx, logger)
+
 		payload, err := c.serializer.Deserialize(m.Value)
 		if err != nil {
-			log.Printf("serialization error: %v\n", err)
+			logger.ErrorContext(msgCtx, "serialization error", slog.String("error", err.Error()))
 			c.reader.CommitMessages(ctx, m)
 			continue
 		}
 
-		if err := handler(ctx, payload); err != nil {
-			log.Printf("handler failed: %v\n", err)
+		if err := handler(msgCtx, payload); err != nil {
+			logger.ErrorContext(msgCtx, "handler failed", slog.String("error", err.Error()))
 			// retry or DLQ.
 			continue
 		}
 
 		if err := c.reader.CommitMessages(ctx, m); err != nil {
-			log.Printf("failed to commit message: %v\n", err)
+			logger.ErrorContext(msgCtx, "failed to commit message", slog.String("error", err.Error()))
 		}
 	}
 }
@@ -110,3 +134,24 @@ func (c *Consumer[T]) Consume(ctx context.Context, handler HandlerFunc[T]) error
 func (c *Consumer[T]) Close() {
 	c.reader.Close()
 }
+
+// messageLogger scopes the consumer logger to a message, handlers get it through logging.FromContext.
+// The request_id and trace_id come from the X-Request-Id and Traceparent headers when present.
+func (c *Consumer[T]) messageLogger(m kgo.Message) *slog.Logger {
+	var requestId, traceId string
+	for _, h := range m.Headers {
+		switch strings.ToLower(h.Key) {
+		case "x-request-id":
+			requestId = string(h.Value)
+		case "traceparent":
+			traceId = logging.TraceId(string(h.Value))
+		}
+	}
+
+	logger := c.logger.With(
+		slog.String("topic", m.Topic),
+		slog.Int("partition", m.Partition),
+		slog.Int64("offset", m.Offset),
+	)
+	return logging.Request(logger, requestId, traceId)
+}
@@ -33,7 +33,8 @@ func TestIntegrationPublisherConsumer(t *testing.T) {
 	assert.NoError(t, err, err)
 	defer prod.Close()
 
-	cons := kafka.NewConsumer(brokers, topic, "item-test-group", testSerializer, nil)
+	cons, err := kafka.NewConsumer(brokers, topic, "item-test-group", testSerializer, nil)
+	assert.NoError(t, err, err)
 	defer cons.Close()
 
 	testItem := item{
@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var ErrInvalidConfig = fmt.Errorf("invalid log configuration")
+
+// Config selects the logger built by New, it is loaded with config.Load, e.g. as a `yaml:"log"` field.
+// Attrs are added to every record as key=value, e.g. app_id=billing.
+type Config struct {
+	Format string     `yaml:"format" env:"LOG_FORMAT" flag:"log-format" default:"json" usage:"log format, json or text"`
+	Level  slog.Level `yaml:"level" env:"LOG_LEVEL" flag:"log-level" default:"info" usage:"log level"`
+	Output string     `yaml:"output" env:"LOG_OUTPUT" flag:"log-output" default:"stdout" usage:"log output, stdout, stderr or a file path"`
+	Source bool       `yaml:"source" env:"LOG_SOURCE" usage:"add the source file and line"`
+	Attrs  []string   `yaml:"attrs" env:"LOG_ATTRS" flag:"log-attrs" usage:"comma separated key=value attributes"`
+}
+
+// New builds the logger of cfg. The returned level changes it at runtime, e.g. through api.NewAdmin.
+// A file output is opened for appending and stays open for the life of the process.
+func New(cfg Config) (*slog.Logger, *slog.LevelVar, error) {
+	var out io.Writer
+	switch cfg.Output {
+	case "", "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		f, err := os.OpenFile(cfg.Output, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = f
+	}
+
+	return NewWriter(out, cfg)
+}
+
+// NewWriter builds the logger of cfg writing to out, the Output of cfg is ignored.
+func NewWriter(out io.Writer, cfg Config) (*slog.Logger, *slog.LevelVar, error) {
+	level := new(slog.LevelVar)
+	level.Set(cfg.Level)
+	opts := &slog.HandlerOptions{Level: level, AddSource: cfg.Source}
+
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "json":
+		handler = slog.NewJSONHandler(out, opts)
+	case "text":
+		handler = slog.NewTextHandler(out, opts)
+	default:
+		return nil, nil, fmt.Errorf("%w: format %q", ErrInvalidConfig, cfg.Format)
+	}
+
+	attrs := make([]slog.Attr, 0, len(cfg.Attrs))
+	for _, kv := range cfg.Attrs {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return nil, nil, fmt.Errorf("%w: attribute %q want key=value", ErrInvalidConfig, kv)
+		}
+		attrs = append(attrs, slog.String(key, value))
+	}
+
+	return slog.New(handler.WithAttrs(attrs)), level, nil
+}
+
+type loggerContextKeyType struct{}
+
+var loggerContextKey = loggerContextKeyType{}
+
+// WithLogger stores a request scoped logger in the context.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey, logger)
+}
+
+// FromContext returns the logger stored with WithLogger, slog.Default otherwise.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// With stores the context logger with args added to it.
+func With(ctx context.Context, args ...any) context.Context {
+	return WithLogger(ctx, FromContext(ctx).With(args...))
+}
+
+// TraceId returns the trace-id field of a W3C traceparent, version-traceid-parentid-flags,
+// or an empty string when it is malformed.
+func TraceId(traceparent string) string {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 {
+		return ""
+	}
+	return parts[1]
+}
+
+// Request adds the request_id and trace_id attributes to logger when they are set.
+func Request(logger *slog.Logger, requestId, traceId string) *slog.Logger {
+	var args []any
+	if requestId != "" {
+		args = append(args, slog.String("request_id", requestId))
+	}
+	if traceId != "" {
+		args = append(args, slog.String("trace_id", traceId))
+	}
+	return logger.With(args...)
+}
+
+// WithRequest stores logger scoped to a request, see Request.
+func WithRequest(ctx context.Context, logger *slog.Logger, requestId, traceId string) context.Context {
+	return WithLogger(ctx, Request(logger, requestId, traceId))
+}
@@ -0,0 +1,76 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bhmt/tittlemanscrest/logging"
+)
+
+func TestNew(t *testing.T) {
+	var out bytes.Buffer
+	logger, level, err := logging.NewWriter(&out, logging.Config{Format: "text", Level: slog.LevelWarn, Attrs: []string{"app_id=billing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("hidden")
+	level.Set(slog.LevelInfo)
+	logger.Info("shown")
+
+	if got := out.String(); strings.Contains(got, "hidden") || !strings.Contains(got, "msg=shown app_id=billing") {
+		t.Errorf("level and attributes mismatch got=%s", got)
+	}
+
+	for _, cfg := range []logging.Config{{Format: "xml"}, {Attrs: []string{"app_id"}}} {
+		if _, _, err := logging.NewWriter(&out, cfg); !errors.Is(err, logging.ErrInvalidConfig) {
+			t.Errorf("%+v want=ErrInvalidConfig got=%v", cfg, err)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "app.log")
+	logger, _, err = logging.New(logging.Config{Output: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("to file")
+	if data, _ := os.ReadFile(file); !strings.Contains(string(data), `"msg":"to file"`) {
+		t.Errorf("file output should be json got=%s", data)
+	}
+}
+
+func TestContext(t *testing.T) {
+	if logging.FromContext(context.Background()) != slog.Default() {
+		t.Error("empty context should return the default logger")
+	}
+
+	var out bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&out, nil))
+
+	ctx := logging.WithRequest(context.Background(), logger, "req-1", "")
+	ctx = logging.With(ctx, "job", "cleanup")
+	logging.FromContext(ctx).Info("scoped")
+
+	if got := out.String(); !strings.Contains(got, "request_id=req-1 job=cleanup") || strings.Contains(got, "trace_id") {
+		t.Errorf("scoped logger attributes mismatch got=%s", got)
+	}
+}
+
+func TestTraceId(t *testing.T) {
+	tests := map[string]string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736":                     "",
+		"":                                                        "",
+	}
+	for traceparent, want := range tests {
+		if got := logging.TraceId(traceparent); got != want {
+			t.Errorf("trace id of %q want=%q got=%q", traceparent, want, got)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+create table if not exists authz_policy (
+    name text primary key,
+    document text not null,
+    updated_at timestamptz not null default now()
+);
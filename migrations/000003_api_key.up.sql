@@ -0,0 +1,12 @@
+create table if not exists api_key (
+    id bigserial primary key,
+    name text not null,
+    prefix text unique not null,
+    salt text not null,
+    hash text not null,
+    scopes text not null default '',
+    created_at timestamptz not null default now(),
+    expires_at timestamptz,
+    revoked_at timestamptz,
+    last_used_at timestamptz
+);
@@ -0,0 +1,4 @@
+create table if not exists feature_flag (
+    name text primary key,
+    definition text not null
+);
@@ -0,0 +1,6 @@
+create table if not exists leader_lease (
+    name text primary key,
+    holder text not null,
+    token bigint not null,
+    expires_at bigint not null
+);
@@ -0,0 +1,4 @@
+create table if not exists job_run (
+    name text primary key,
+    last_run bigint not null
+);
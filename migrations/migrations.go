@@ -0,0 +1,7 @@
+// Package migrations embeds the Postgres schema, apply it with repository.NewMigrator.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ApiKeyTag starts every api key, followed by the visible prefix id and the secret.
+const ApiKeyTag = "tk"
+
+var (
+	ErrApiKeyInvalid  = fmt.Errorf("invalid api key")
+	ErrApiKeyNotFound = fmt.Errorf("api key not found")
+	ErrApiKeyRevoked  = fmt.Errorf("api key revoked")
+	ErrApiKeyExpired  = fmt.Errorf("api key expired")
+)
+
+// ApiKey is a machine client credential.
+// Only the visible prefix and a salted hash of the secret are stored,
+// the full key is returned once by NewApiKey and Rotate.
+type ApiKey struct {
+	Entity
+	Name       string
+	Prefix     string
+	Salt       string
+	Hash       string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  sql.NullTime
+	RevokedAt  sql.NullTime
+	LastUsedAt sql.NullTime
+}
+
+// NewApiKey generates a key, a zero expires never expires.
+// The returned key must be handed to the client, it can not be recovered.
+func NewApiKey(name string, scopes []string, expires time.Time) (ApiKey, string, error) {
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	salt := make([]byte, 16)
+	for _, b := range [][]byte{id, secret, salt} {
+		if _, err := rand.Read(b); err != nil {
+			return ApiKey{}, "", err
+		}
+	}
+
+	entity := ApiKey{
+		Name:      name,
+		Prefix:    ApiKeyTag + "_" + hex.EncodeToString(id),
+		Salt:      hex.EncodeToString(salt),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: sql.NullTime{Time: expires.UTC(), Valid: !expires.IsZero()},
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	entity.Hash = entity.hash(encoded)
+	return entity, entity.Prefix + "_" + encoded, nil
+}
+
+// ParseApiKey splits a key into its visible prefix and secret.
+func ParseApiKey(key string) (prefix string, secret string, err error) {
+	rest, ok := strings.CutPrefix(key, ApiKeyTag+"_")
+	if !ok {
+		return "", "", ErrApiKeyInvalid
+	}
+
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || id == "" || secret == "" {
+		return "", "", ErrApiKeyInvalid
+	}
+	return ApiKeyTag + "_" + id, secret, nil
+}
+
+func (entity *ApiKey) hash(secret string) string {
+	sum := sha256.Sum256([]byte(entity.Salt + secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify reports whether the secret matches the stored hash.
+func (entity *ApiKey) Verify(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(entity.hash(secret)), []byte(entity.Hash)) == 1
+}
+
+// Valid returns ErrApiKeyRevoked or ErrApiKeyExpired when the key can not be used at now.
+func (entity *ApiKey) Valid(now time.Time) error {
+	if entity.RevokedAt.Valid && !now.Before(entity.RevokedAt.Time) {
+		return ErrApiKeyRevoked
+	}
+	if entity.ExpiresAt.Valid && !now.Before(entity.ExpiresAt.Time) {
+		return ErrApiKeyExpired
+	}
+	return nil
+}
+
+func (entity *ApiKey) HasScope(scope string) bool {
+	return slices.Contains(entity.Scopes, scope)
+}
+
+const apiKeyColumns = `id, name, prefix, salt, hash, scopes, created_at, expires_at, revoked_at, last_used_at`
+
+func (entity *ApiKey) scan(row *sql.Row) error {
+	var scopes string
+	err := row.Scan(
+		&entity.Id, &entity.Name, &entity.Prefix, &entity.Salt, &entity.Hash, &scopes,
+		&entity.CreatedAt, &entity.ExpiresAt, &entity.RevokedAt, &entity.LastUsedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrApiKeyNotFound
+	}
+	entity.Scopes = strings.Fields(scopes)
+	return err
+}
+
+func (entity *ApiKey) Create(w Worker, ctx context.Context) error {
+	query := `insert into api_key (name, prefix, salt, hash, scopes, created_at, expires_at)
+values ($1, $2, $3, $4, $5, $6, $7) returning id`
+	return w.QueryRowContext(
+		ctx, query,
+		entity.Name, entity.Prefix, entity.Salt, entity.Hash, strings.Join(entity.Scopes, " "),
+		entity.CreatedAt, entity.ExpiresAt,
+	).Scan(&entity.Id)
+}
+
+// Read loads the key by Id.
+func (entity *ApiKey) Read(w Worker, ctx context.Context) error {
+	query := `select ` + apiKeyColumns + ` from api_key where id = $1`
+	return entity.scan(w.QueryRowContext(ctx, query, entity.Id))
+}
+
+// ReadByPrefix loads the key by Prefix.
+func (entity *ApiKey) ReadByPrefix(w Worker, ctx context.Context) error {
+	query := `select ` + apiKeyColumns + ` from api_key where prefix = $1`
+	return entity.scan(w.QueryRowContext(ctx, query, entity.Prefix))
+}
+
+// Update stores the name, scopes and expiry.
+func (entity *ApiKey) Update(w Worker, ctx context.Context) (int64, error) {
+	query := `update api_key set name = $1, scopes = $2, expires_at = $3 where id = $4`
+	result, err := w.ExecContext(ctx, query, entity.Name, strings.Join(entity.Scopes, " "), entity.ExpiresAt, entity.Id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (entity *ApiKey) Delete(w Worker, ctx context.Context) (int64, error) {
+	result, err := w.ExecContext(ctx, `delete from api_key where id = $1`, entity.Id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Revoke disables the key at the given time, e.g. later than now to leave a grace period.
+func (entity *ApiKey) Revoke(w Worker, ctx context.Context, at time.Time) (int64, error) {
+	query := `update api_key set revoked_at = $1 where id = $2 and revoked_at is null`
+	result, err := w.ExecContext(ctx, query, at.UTC(), entity.Id)
+	if err != nil {
+		return 0, err
+	}
+
+	entity.RevokedAt = sql.NullTime{Time: at.UTC(), Valid: true}
+	return result.RowsAffected()
+}
+
+// Rotate creates a key with the same name, scopes and expiry and revokes this one after grace.
+// Use a transactional UnitOfWork worker so both changes apply together.
+func (entity *ApiKey) Rotate(w Worker, ctx context.Context, grace time.Duration) (ApiKey, string, error) {
+	var expires time.Time
+	if entity.ExpiresAt.Valid {
+		expires = entity.ExpiresAt.Time
+	}
+
+	rotated, key, err := NewApiKey(entity.Name, entity.Scopes, expires)
+	if err != nil {
+		return ApiKey{}, "", err
+	}
+
+	if err := rotated.Create(w, ctx); err != nil {
+		return ApiKey{}, "", err
+	}
+
+	if _, err := entity.Revoke(w, ctx, time.Now().Add(grace)); err != nil {
+		return ApiKey{}, "", err
+	}
+	return rotated, key, nil
+}
+
+// TouchApiKeys sets the last used time of the keys by id.
+func TouchApiKeys(w Worker, ctx context.Context, used map[int64]time.Time) error {
+	stmt, err := w.PrepareContext(ctx, `update api_key set last_used_at = $1 where id = $2`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for id, at := range used {
+		if _, err := stmt.ExecContext(ctx, at.UTC(), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
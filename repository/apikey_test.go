@@ -0,0 +1,104 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/repository"
+	_ "modernc.org/sqlite"
+)
+
+var apiKeyMigration = `
+create table if not exists api_key (
+    id integer primary key autoincrement not null,
+    name text not null,
+    prefix text unique not null,
+    salt text not null,
+    hash text not null,
+    scopes text not null default '',
+    created_at timestamp not null,
+    expires_at timestamp,
+    revoked_at timestamp,
+    last_used_at timestamp
+)
+`
+
+func TestApiKey(t *testing.T) {
+	ctx := context.Background()
+	if _, err := s.ExecContext(ctx, apiKeyMigration); err != nil {
+		t.Fatal(err)
+	}
+
+	entity, key, err := repository.NewApiKey("billing", []string{"invoices:read"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Create(s, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix, secret, err := repository.ParseApiKey(key)
+	if err != nil || prefix != entity.Prefix {
+		t.Fatalf("parse want prefix=%s got=%s err=%v", entity.Prefix, prefix, err)
+	}
+
+	stored := repository.ApiKey{Prefix: prefix}
+	if err := stored.ReadByPrefix(s, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Verify(secret) || stored.Verify(secret+"x") || !stored.HasScope("invoices:read") {
+		t.Error("stored key should verify its secret and keep its scopes")
+	}
+	if stored.Hash == secret || stored.Valid(time.Now()) != nil {
+		t.Error("stored key should be hashed and valid")
+	}
+
+	uow, err := repository.NewUnitOfWork(s, repository.WithTransaction(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, rotatedKey, err := stored.Rotate(uow.Worker, ctx, 0)
+	if err != nil {
+		uow.Rollback()
+		t.Fatal(err)
+	}
+	if err := uow.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	old := repository.ApiKey{Entity: stored.Entity}
+	if err := old.Read(s, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Valid(time.Now()); !errors.Is(err, repository.ErrApiKeyRevoked) {
+		t.Errorf("rotated key want=%v got=%v", repository.ErrApiKeyRevoked, err)
+	}
+
+	_, rotatedSecret, _ := repository.ParseApiKey(rotatedKey)
+	if !rotated.Verify(rotatedSecret) || rotated.Name != "billing" {
+		t.Error("rotated key should keep the name and verify the new secret")
+	}
+
+	if err := repository.TouchApiKeys(s, ctx, map[int64]time.Time{rotated.Id: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rotated.Read(s, ctx); err != nil || !rotated.LastUsedAt.Valid {
+		t.Errorf("last used want set got=%v err=%v", rotated.LastUsedAt, err)
+	}
+
+	expired, _, _ := repository.NewApiKey("old", nil, time.Now().Add(-time.Minute))
+	if err := expired.Valid(time.Now()); !errors.Is(err, repository.ErrApiKeyExpired) {
+		t.Errorf("expired key want=%v got=%v", repository.ErrApiKeyExpired, err)
+	}
+
+	missing := repository.ApiKey{Prefix: "tk_missing"}
+	if err := missing.ReadByPrefix(s, ctx); !errors.Is(err, repository.ErrApiKeyNotFound) {
+		t.Errorf("missing key want=%v got=%v", repository.ErrApiKeyNotFound, err)
+	}
+
+	if _, _, err := repository.ParseApiKey("nope"); !errors.Is(err, repository.ErrApiKeyInvalid) {
+		t.Errorf("malformed key want=%v got=%v", repository.ErrApiKeyInvalid, err)
+	}
+}
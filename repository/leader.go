@@ -0,0 +1,260 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrLeadershipLost = fmt.Errorf("leadership lost")
+
+type fencingTokenKey struct{}
+
+// FencingToken returns the token of the leadership held by ctx.
+// Tokens grow with every new leader, pass them along writes so stale leaders can be rejected.
+func FencingToken(ctx context.Context) (int64, bool) {
+	token, ok := ctx.Value(fencingTokenKey{}).(int64)
+	return token, ok
+}
+
+// Election configures a campaign for the leadership of name.
+type Election struct {
+	name   string
+	holder string
+	ttl    time.Duration
+	retry  time.Duration
+}
+
+// WithElectionTTL sets how long a lease is held without a heartbeat, 15s by default.
+// Heartbeats and connection checks run every third of it.
+func WithElectionTTL(ttl time.Duration) func(*Election) {
+	return func(e *Election) {
+		e.ttl = ttl
+	}
+}
+
+// WithElectionRetry sets how often a follower tries to acquire the leadership, 1s by default.
+func WithElectionRetry(retry time.Duration) func(*Election) {
+	return func(e *Election) {
+		e.retry = retry
+	}
+}
+
+// WithElectionHolder identifies the replica in the lease table, hostname-pid-random by default.
+func WithElectionHolder(holder string) func(*Election) {
+	return func(e *Election) {
+		e.holder = holder
+	}
+}
+
+func newElection(name string, opts []func(*Election)) Election {
+	e := Election{name: name, ttl: 15 * time.Second, retry: time.Second}
+	for _, o := range opts {
+		o(&e)
+	}
+
+	if e.holder == "" {
+		e.holder = newHolder()
+	}
+	return e
+}
+
+// newHolder identifies a replica holding a lock or lease, hostname-pid-random.
+func newHolder() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// Name returns the name of the election.
+func (e Election) Name() string {
+	return e.name
+}
+
+// wait sleeps for the retry interval, it returns false when ctx is done first.
+func (e Election) wait(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(e.retry):
+		return true
+	}
+}
+
+// lead returns the leadership context and its resign function.
+// check runs every third of the ttl under its own deadline and cancels the leadership when it fails,
+// release runs once on resign.
+func (e Election) lead(ctx context.Context, token int64, check func(context.Context) error, release func() error) (context.Context, func() error) {
+	ctx, cancel := context.WithCancelCause(context.WithValue(ctx, fencingTokenKey{}, token))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(e.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := check(ctx); err != nil {
+					cancel(fmt.Errorf("%w: %w", ErrLeadershipLost, err))
+					return
+				}
+			}
+		}
+	}()
+
+	resign := sync.OnceValue(func() error {
+		cancel(context.Canceled)
+		<-done
+		return release()
+	})
+	return ctx, resign
+}
+
+// AdvisoryElection elects a leader holding a Postgres session advisory lock.
+// The lock is released by the database when the connection of the leader drops.
+type AdvisoryElection struct {
+	Election
+	session *Session
+	key     int64
+}
+
+func NewAdvisoryElection(session *Session, name string, opts ...func(*Election)) *AdvisoryElection {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return &AdvisoryElection{Election: newElection(name, opts), session: session, key: int64(h.Sum64())}
+}
+
+// Campaign blocks until the lock is acquired and returns a context cancelled when the connection
+// holding it fails, with ErrLeadershipLost as cause. The fencing token is the current transaction id.
+// Call resign to release the leadership.
+func (e *AdvisoryElection) Campaign(ctx context.Context) (context.Context, func() error, error) {
+	conn, err := e.session.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		var locked bool
+		if err := conn.QueryRowContext(ctx, `select pg_try_advisory_lock($1)`, e.key).Scan(&locked); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		if locked {
+			break
+		}
+		if !e.wait(ctx) {
+			conn.Close()
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	var token int64
+	if err := conn.QueryRowContext(ctx, `select txid_current()`).Scan(&token); err != nil {
+		conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, e.key)
+		conn.Close()
+		return nil, nil, err
+	}
+
+	check := func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, e.ttl/3)
+		defer cancel()
+
+		_, err := conn.ExecContext(ctx, `select 1`)
+		return err
+	}
+	release := func() error {
+		_, err := conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, e.key)
+		return errors.Join(err, conn.Close())
+	}
+
+	lead, resign := e.lead(ctx, token, check, release)
+	return lead, resign, nil
+}
+
+// LeaseElection elects a leader holding a row of the leader_lease table, see migrations/000005_leader_lease.
+// The leader renews its lease with heartbeats, an expired lease is taken over with the next token.
+// Expiry is compared with the clock of each replica, keep them in sync well within the ttl.
+type LeaseElection struct {
+	Election
+	session *Session
+}
+
+func NewLeaseElection(session *Session, name string, opts ...func(*Election)) *LeaseElection {
+	return &LeaseElection{Election: newElection(name, opts), session: session}
+}
+
+// Campaign blocks until the lease is acquired and returns a context cancelled when a heartbeat
+// finds the lease taken over or cannot renew it before it expires, with ErrLeadershipLost as cause.
+// Call resign to release the leadership.
+func (e *LeaseElection) Campaign(ctx context.Context) (context.Context, func() error, error) {
+	query := `
+insert into leader_lease (name, holder, token, expires_at) values ($1, $2, 1, $3)
+on conflict (name) do update set holder = excluded.holder, token = leader_lease.token + 1, expires_at = excluded.expires_at
+where leader_lease.expires_at < $4
+returning token
+`
+
+	var token int64
+	var now time.Time
+	for {
+		now = time.Now()
+		err := e.session.QueryRowContext(ctx, query, e.name, e.holder, now.Add(e.ttl).UnixMilli(), now.UnixMilli()).Scan(&token)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, err
+		}
+		if !e.wait(ctx) {
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	expires := now.Add(e.ttl)
+	check := func(ctx context.Context) error {
+		query := `update leader_lease set expires_at = $1 where name = $2 and holder = $3 and token = $4`
+
+		// A renewal still blocked when the lease expires must not outlive it.
+		ctx, cancel := context.WithDeadline(ctx, expires)
+		defer cancel()
+
+		now := time.Now()
+		result, err := e.session.ExecContext(ctx, query, now.Add(e.ttl).UnixMilli(), e.name, e.holder, token)
+		if err == nil {
+			var n int64
+			if n, err = result.RowsAffected(); err == nil && n == 0 {
+				return fmt.Errorf("lease %s taken over", e.name)
+			}
+		}
+		if err != nil {
+			if time.Until(expires) < e.ttl/3 {
+				return err
+			}
+			return nil
+		}
+
+		expires = now.Add(e.ttl)
+		return nil
+	}
+	release := func() error {
+		query := `update leader_lease set expires_at = $1 where name = $2 and holder = $3 and token = $4`
+		_, err := e.session.ExecContext(context.Background(), query, time.Now().UnixMilli(), e.name, e.holder, token)
+		return err
+	}
+
+	lead, resign := e.lead(ctx, token, check, release)
+	return lead, resign, nil
+}
@@ -0,0 +1,59 @@
+//go:build integration
+
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/repository"
+	_ "github.com/lib/pq"
+)
+
+func TestIntegrationAdvisoryElection(t *testing.T) {
+	postgres_db, ok := os.LookupEnv("POSTGRES_DB")
+	if !ok {
+		postgres_db = "localhost"
+	}
+
+	dns := fmt.Sprintf("postgresql://postgres:postgres@%s:5432/gotest?sslmode=disable", postgres_db)
+	session, err := repository.NewSession("postgres", dns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := repository.WithElectionRetry(10 * time.Millisecond)
+	first := repository.NewAdvisoryElection(session, "relay", opts)
+	second := repository.NewAdvisoryElection(session, "relay", opts)
+
+	ctx := context.Background()
+	lead, resign, err := first.Campaign(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstToken, _ := repository.FencingToken(lead)
+
+	short, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if _, _, err := second.Campaign(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("lock should be held, want=DeadlineExceeded got=%v", err)
+	}
+
+	if err := resign(); err != nil {
+		t.Fatal(err)
+	}
+
+	lead, resign, err = second.Campaign(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resign()
+
+	if token, _ := repository.FencingToken(lead); token <= firstToken {
+		t.Errorf("fencing token should grow, first=%d second=%d", firstToken, token)
+	}
+}
@@ -0,0 +1,76 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/repository"
+	_ "modernc.org/sqlite"
+)
+
+var leaderMigration = `
+create table if not exists leader_lease (
+    name text primary key,
+    holder text not null,
+    token bigint not null,
+    expires_at bigint not null
+)
+`
+
+func TestLeaseElection(t *testing.T) {
+	ctx := context.Background()
+	if _, err := s.ExecContext(ctx, leaderMigration); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := []func(*repository.Election){repository.WithElectionTTL(300 * time.Millisecond), repository.WithElectionRetry(10 * time.Millisecond)}
+	first := repository.NewLeaseElection(s, "relay", append(opts, repository.WithElectionHolder("first"))...)
+	second := repository.NewLeaseElection(s, "relay", append(opts, repository.WithElectionHolder("second"))...)
+
+	lead, resign, err := first.Campaign(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstToken, ok := repository.FencingToken(lead)
+	if !ok {
+		t.Fatal("leadership context should carry a fencing token")
+	}
+
+	short, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+	if _, _, err := second.Campaign(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("heartbeats should keep the lease, want=DeadlineExceeded got=%v", err)
+	}
+	if lead.Err() != nil {
+		t.Fatalf("leader should keep the lease got=%v", context.Cause(lead))
+	}
+
+	if err := resign(); err != nil {
+		t.Fatal(err)
+	}
+	if lead.Err() == nil {
+		t.Error("resign should cancel the leadership context")
+	}
+
+	lead, _, err = second.Campaign(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := repository.FencingToken(lead); token <= firstToken {
+		t.Errorf("fencing token should grow, first=%d second=%d", firstToken, token)
+	}
+
+	if _, err := s.ExecContext(ctx, `update leader_lease set holder = 'intruder' where name = 'relay'`); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lead.Done():
+		if !errors.Is(context.Cause(lead), repository.ErrLeadershipLost) {
+			t.Errorf("cause want=ErrLeadershipLost got=%v", context.Cause(lead))
+		}
+	case <-time.After(time.Second):
+		t.Error("taken over lease should cancel the leadership context")
+	}
+}
@@ -0,0 +1,682 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/logging"
+)
+
+var (
+	ErrMigrationDirty    = fmt.Errorf("migration left the database dirty")
+	ErrMigrationChecksum = fmt.Errorf("migration checksum mismatch")
+	ErrMigrationNotFound = fmt.Errorf("migration not found")
+	ErrMigrationInvalid  = fmt.Errorf("invalid migration")
+	ErrMigrationSteps    = fmt.Errorf("invalid migration steps")
+)
+
+// Dialect holds the statements keeping the schema_history table of a database.
+// Lock serializes migration runs and returns the matching unlock, it is optional.
+// Transactional dialects run each migration and its history row in one transaction,
+// others mark the version dirty first and leave it dirty when the migration fails.
+// Split runs the statements of a file one at a time for drivers executing a single statement.
+type Dialect struct {
+	Name          string
+	Transactional bool
+	Split         bool
+	Lock          func(ctx context.Context, conn *sql.Conn) (func() error, error)
+	Create        string
+	Select        string
+	Mark          string
+	Remove        string
+}
+
+// migrationLockKey is the advisory lock shared by every migrator of a database.
+const migrationLockKey = 7_467_276_156_143_745
+
+var Postgres = Dialect{
+	Name:          "postgres",
+	Transactional: true,
+	Lock: func(ctx context.Context, conn *sql.Conn) (func() error, error) {
+		if _, err := conn.ExecContext(ctx, `select pg_advisory_lock($1)`, migrationLockKey); err != nil {
+			return nil, err
+		}
+		return func() error {
+			_, err := conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, migrationLockKey)
+			return err
+		}, nil
+	},
+	Create: `create table if not exists schema_history (
+    version bigint primary key,
+    name text not null,
+    checksum text not null,
+    dirty boolean not null,
+    applied_at timestamptz not null default now()
+)`,
+	Select: `select version, name, checksum, dirty from schema_history order by version`,
+	Mark: `insert into schema_history (version, name, checksum, dirty) values ($1, $2, $3, $4)
+on conflict (version) do update set name = excluded.name, checksum = excluded.checksum, dirty = excluded.dirty, applied_at = now()`,
+	Remove: `delete from schema_history where version = $1`,
+}
+
+// SQLite has no advisory locks, a row in schema_lock is held for the run instead.
+// A lock left behind by a crashed run is taken over after 10 minutes, see SQLiteLock.
+var SQLite = Dialect{
+	Name:          "sqlite",
+	Transactional: true,
+	Lock:          SQLiteLock(10 * time.Minute),
+	Create: `create table if not exists schema_history (
+    version integer primary key,
+    name text not null,
+    checksum text not null,
+    dirty boolean not null,
+    applied_at timestamp not null default current_timestamp
+)`,
+	Select: `select version, name, checksum, dirty from schema_history order by version`,
+	Mark: `insert into schema_history (version, name, checksum, dirty) values (?, ?, ?, ?)
+on conflict (version) do update set name = excluded.name, checksum = excluded.checksum, dirty = excluded.dirty, applied_at = current_timestamp`,
+	Remove: `delete from schema_history where version = ?`,
+}
+
+// SQLiteLock holds the schema_lock row with the holder and time of the run.
+// The time is refreshed every third of stale while the lock is held, a row older than stale
+// is left by a run that never unlocked and is taken over. Deleting the row unlocks by hand.
+func SQLiteLock(stale time.Duration) func(ctx context.Context, conn *sql.Conn) (func() error, error) {
+	return func(ctx context.Context, conn *sql.Conn) (func() error, error) {
+		query := `create table if not exists schema_lock (id integer primary key, holder text not null, locked_at integer not null)`
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return nil, err
+		}
+
+		holder := newHolder()
+		query = `insert into schema_lock (id, holder, locked_at) values (1, ?, ?)
+on conflict (id) do update set holder = excluded.holder, locked_at = excluded.locked_at
+where schema_lock.locked_at < ?`
+		for {
+			now := time.Now()
+			result, err := conn.ExecContext(ctx, query, holder, now.UnixMilli(), now.Add(-stale).UnixMilli())
+			if err != nil {
+				return nil, err
+			}
+			if n, err := result.RowsAffected(); err != nil {
+				return nil, err
+			} else if n == 1 {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+
+		stop := refreshLock(ctx, stale/3, func(ctx context.Context) error {
+			_, err := conn.ExecContext(ctx, `update schema_lock set locked_at = ? where id = 1 and holder = ?`, time.Now().UnixMilli(), holder)
+			return err
+		})
+		return func() error {
+			stop()
+			_, err := conn.ExecContext(context.Background(), `delete from schema_lock where id = 1 and holder = ?`, holder)
+			return err
+		}, nil
+	}
+}
+
+// refreshLock runs refresh every interval until the returned stop is called, failures are logged.
+// Refreshes share the connection of the run and are serialized with its statements.
+func refreshLock(ctx context.Context, interval time.Duration, refresh func(context.Context) error) func() {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := refresh(ctx); err != nil && ctx.Err() == nil {
+					logging.FromContext(ctx).WarnContext(ctx, "migration lock refresh failed", slog.String("error", err.Error()))
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// ClickHouseLock elects the oldest live row of schema_lock as the holder of the lock.
+// ClickHouse cannot insert a row only when absent, so every migrator inserts its own row,
+// refreshes it every third of stale while waiting and running, and runs once its row is the
+// oldest one neither released nor stale. Rows are ordered by the server clock.
+func ClickHouseLock(stale time.Duration) func(ctx context.Context, conn *sql.Conn) (func() error, error) {
+	return func(ctx context.Context, conn *sql.Conn) (func() error, error) {
+		query := `create table if not exists schema_lock (
+    holder String,
+    acquired_at DateTime64(6),
+    heartbeat_at DateTime64(6),
+    released Bool default false
+)
+engine = ReplacingMergeTree(heartbeat_at)
+order by holder
+ttl toDateTime(heartbeat_at) + interval 1 day`
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return nil, err
+		}
+
+		holder := newHolder()
+		query = `insert into schema_lock (holder, acquired_at, heartbeat_at) select ?, now64(6), now64(6)`
+		if _, err := conn.ExecContext(ctx, query, holder); err != nil {
+			return nil, err
+		}
+
+		stop := refreshLock(ctx, stale/3, func(ctx context.Context) error {
+			query := `insert into schema_lock (holder, acquired_at, heartbeat_at)
+select holder, acquired_at, now64(6) from schema_lock final where holder = ? and not released`
+			_, err := conn.ExecContext(ctx, query, holder)
+			return err
+		})
+		unlock := func() error {
+			stop()
+			query := `insert into schema_lock (holder, acquired_at, heartbeat_at, released)
+select holder, acquired_at, now64(6), true from schema_lock final where holder = ?`
+			_, err := conn.ExecContext(context.Background(), query, holder)
+			return err
+		}
+
+		query = `select holder from schema_lock final
+where not released and heartbeat_at > now64(6) - toIntervalMillisecond(?)
+order by acquired_at, holder limit 1`
+		for {
+			var oldest string
+			err := conn.QueryRowContext(ctx, query, stale.Milliseconds()).Scan(&oldest)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				unlock()
+				return nil, err
+			}
+			if oldest == holder {
+				return unlock, nil
+			}
+
+			select {
+			case <-ctx.Done():
+				unlock()
+				return nil, ctx.Err()
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+// ClickHouse has no transactions, a failed migration leaves its version dirty.
+// The history is only inserted into, the latest row of a version wins.
+// Runs are serialized by ClickHouseLock, a lock left behind is taken over after 10 minutes.
+var ClickHouse = Dialect{
+	Name:  "clickhouse",
+	Split: true,
+	Lock:  ClickHouseLock(10 * time.Minute),
+	Create: `create table if not exists schema_history (
+    version Int64,
+    name String,
+    checksum String,
+    dirty Bool,
+    removed Bool default false,
+    applied_at DateTime64(6) default now64(6)
+)
+engine = ReplacingMergeTree(applied_at)
+order by version`,
+	Select: `select version, name, checksum, dirty from schema_history final where not removed order by version`,
+	Mark:   `insert into schema_history (version, name, checksum, dirty) values (?, ?, ?, ?)`,
+	Remove: `insert into schema_history (version, name, checksum, dirty, removed) values (?, '', '', false, true)`,
+}
+
+// Dialects maps driver names to their dialect.
+var Dialects = map[string]Dialect{
+	"postgres":   Postgres,
+	"pgx":        Postgres,
+	"sqlite":     SQLite,
+	"clickhouse": ClickHouse,
+}
+
+// Migration is a version read from a pair of files, e.g. 000001_init.up.sql and 000001_init.down.sql.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus is a migration with its schema_history row.
+// Missing is set for versions applied to the database but absent from the files.
+type MigrationStatus struct {
+	Migration
+	Applied         bool
+	Dirty           bool
+	Missing         bool
+	AppliedChecksum string
+}
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// ReadMigrations parses the migration files found in dir of fsys, sorted by version.
+// Every version needs an up file, down files are optional but versions without one cannot be reverted.
+func ReadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrMigrationInvalid, entry.Name(), err)
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("%w: version %d named %s and %s", ErrMigrationInvalid, version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(data)
+			sum := sha256.Sum256(data)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Checksum == "" {
+			return nil, fmt.Errorf("%w: version %d has no up file", ErrMigrationInvalid, m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return cmp.Compare(a.Version, b.Version) })
+	return migrations, nil
+}
+
+// Migrator applies migrations to the database of a session.
+type Migrator struct {
+	session    *Session
+	dialect    Dialect
+	migrations []Migration
+}
+
+// NewMigrator reads the migrations in the root of fsys, e.g. an embed.FS or os.DirFS.
+func NewMigrator(session *Session, dialect Dialect, fsys fs.FS) (*Migrator, error) {
+	migrations, err := ReadMigrations(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{session: session, dialect: dialect, migrations: migrations}, nil
+}
+
+// Migrations returns the migrations read from the files.
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Status lists every migration file and applied version sorted by version.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	conn, err := m.session.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, m.dialect.Create); err != nil {
+		return nil, err
+	}
+	return m.status(ctx, conn)
+}
+
+func (m *Migrator) status(ctx context.Context, conn *sql.Conn) ([]MigrationStatus, error) {
+	rows, err := conn.QueryContext(ctx, m.dialect.Select)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byVersion := map[int64]*MigrationStatus{}
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		statuses = append(statuses, MigrationStatus{Migration: migration})
+	}
+	for i := range statuses {
+		byVersion[statuses[i].Version] = &statuses[i]
+	}
+
+	var missing []MigrationStatus
+	for rows.Next() {
+		var version int64
+		var name, checksum string
+		var dirty bool
+		if err := rows.Scan(&version, &name, &checksum, &dirty); err != nil {
+			return nil, err
+		}
+
+		s, ok := byVersion[version]
+		if !ok {
+			missing = append(missing, MigrationStatus{
+				Migration: Migration{Version: version, Name: name},
+				Applied:   true, Dirty: dirty, Missing: true, AppliedChecksum: checksum,
+			})
+			continue
+		}
+		s.Applied, s.Dirty, s.AppliedChecksum = true, dirty, checksum
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses = append(statuses, missing...)
+	slices.SortFunc(statuses, func(a, b MigrationStatus) int { return cmp.Compare(a.Version, b.Version) })
+	return statuses, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) error {
+	if len(m.migrations) == 0 {
+		return nil
+	}
+	return m.Goto(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down reverts the latest steps applied migrations, steps must not be negative.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	if steps < 0 {
+		return fmt.Errorf("%w: %d", ErrMigrationSteps, steps)
+	}
+
+	return m.run(ctx, func(statuses []MigrationStatus) (int64, error) {
+		var applied []int64
+		for _, s := range statuses {
+			if s.Applied {
+				applied = append(applied, s.Version)
+			}
+		}
+
+		if steps >= len(applied) {
+			return 0, nil
+		}
+		return applied[len(applied)-steps-1], nil
+	})
+}
+
+// Goto applies or reverts migrations until version is the latest applied one, 0 reverts all.
+func (m *Migrator) Goto(ctx context.Context, version int64) error {
+	return m.run(ctx, func([]MigrationStatus) (int64, error) {
+		if version == 0 {
+			return 0, nil
+		}
+
+		for _, migration := range m.migrations {
+			if migration.Version == version {
+				return version, nil
+			}
+		}
+		return 0, fmt.Errorf("%w: version %d", ErrMigrationNotFound, version)
+	})
+}
+
+// Force marks the versions up to version as applied and clean, and the later ones as not applied,
+// without running any migration. It is used to recover after fixing a dirty database by hand.
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	return m.locked(ctx, func(conn *sql.Conn, statuses []MigrationStatus) error {
+		logging.FromContext(ctx).WarnContext(ctx, "migration forced", slog.Int64("version", version))
+		for _, s := range statuses {
+			var err error
+			if s.Version <= version && !s.Missing {
+				_, err = conn.ExecContext(ctx, m.dialect.Mark, s.Version, s.Name, s.Checksum, false)
+			} else if s.Applied {
+				_, err = conn.ExecContext(ctx, m.dialect.Remove, s.Version)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// locked runs fn holding the dialect lock on a single connection.
+func (m *Migrator) locked(ctx context.Context, fn func(*sql.Conn, []MigrationStatus) error) error {
+	conn, err := m.session.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.dialect.Lock != nil {
+		unlock, err := m.dialect.Lock(ctx, conn)
+		if err != nil {
+			return fmt.Errorf("migration lock: %w", err)
+		}
+		defer unlock()
+	}
+
+	if _, err := conn.ExecContext(ctx, m.dialect.Create); err != nil {
+		return err
+	}
+
+	statuses, err := m.status(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, statuses)
+}
+
+// run moves the database to the version returned by target.
+// It refuses to start on a dirty database or when an applied file changed.
+func (m *Migrator) run(ctx context.Context, target func([]MigrationStatus) (int64, error)) error {
+	return m.locked(ctx, func(conn *sql.Conn, statuses []MigrationStatus) error {
+		for _, s := range statuses {
+			if s.Dirty {
+				return fmt.Errorf("%w: version %d", ErrMigrationDirty, s.Version)
+			}
+			if s.Applied && !s.Missing && s.AppliedChecksum != s.Checksum {
+				return fmt.Errorf("%w: version %d %s", ErrMigrationChecksum, s.Version, s.Name)
+			}
+		}
+
+		version, err := target(statuses)
+		if err != nil {
+			return err
+		}
+
+		// Every revert is checked first, so a missing down file leaves the database untouched.
+		var reverts []Migration
+		for i := len(statuses) - 1; i >= 0; i-- {
+			s := statuses[i]
+			if !s.Applied || s.Version <= version {
+				continue
+			}
+			if s.Missing {
+				return fmt.Errorf("%w: version %d %s is applied", ErrMigrationNotFound, s.Version, s.Name)
+			}
+			if strings.TrimSpace(s.Down) == "" {
+				return fmt.Errorf("%w: version %d %s has no down file", ErrMigrationInvalid, s.Version, s.Name)
+			}
+			reverts = append(reverts, s.Migration)
+		}
+		for _, migration := range reverts {
+			if err := m.apply(ctx, conn, migration, false); err != nil {
+				return err
+			}
+		}
+
+		for _, s := range statuses {
+			if s.Applied || s.Version > version {
+				continue
+			}
+			if err := m.apply(ctx, conn, s.Migration, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// apply runs a migration and logs it through the context logger.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	direction := "up"
+	if !up {
+		direction = "down"
+	}
+
+	start := time.Now()
+	logger := logging.FromContext(ctx).With(slog.Int64("version", migration.Version), slog.String("name", migration.Name), slog.String("direction", direction))
+	if err := m.applyScript(ctx, conn, migration, up); err != nil {
+		logger.ErrorContext(ctx, "migration failed", slog.String("error", err.Error()))
+		return err
+	}
+
+	logger.InfoContext(ctx, "migration applied", slog.Duration("duration", time.Since(start)))
+	return nil
+}
+
+func (m *Migrator) applyScript(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	script, direction := migration.Up, "up"
+	if !up {
+		script, direction = migration.Down, "down"
+	}
+	wrap := func(err error) error {
+		return fmt.Errorf("migration %d %s %s: %w", migration.Version, migration.Name, direction, err)
+	}
+
+	record := func(w execer) error {
+		var err error
+		if up {
+			_, err = w.ExecContext(ctx, m.dialect.Mark, migration.Version, migration.Name, migration.Checksum, false)
+		} else {
+			_, err = w.ExecContext(ctx, m.dialect.Remove, migration.Version)
+		}
+		return err
+	}
+
+	if m.dialect.Transactional {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return wrap(err)
+		}
+		defer tx.Rollback()
+
+		if err := m.exec(ctx, tx, script); err != nil {
+			return wrap(err)
+		}
+		if err := record(tx); err != nil {
+			return wrap(err)
+		}
+		if err := tx.Commit(); err != nil {
+			return wrap(err)
+		}
+		return nil
+	}
+
+	if _, err := conn.ExecContext(ctx, m.dialect.Mark, migration.Version, migration.Name, migration.Checksum, true); err != nil {
+		return wrap(err)
+	}
+	if err := m.exec(ctx, conn, script); err != nil {
+		return wrap(err)
+	}
+	if err := record(conn); err != nil {
+		return wrap(err)
+	}
+	return nil
+}
+
+// execer is implemented by *sql.Conn and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (m *Migrator) exec(ctx context.Context, w execer, script string) error {
+	statements := []string{script}
+	if m.dialect.Split {
+		statements = splitStatements(script)
+	}
+
+	for _, statement := range statements {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		if _, err := w.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitStatements splits a script on semicolons outside of quotes and comments.
+func splitStatements(script string) []string {
+	var statements []string
+	var quote rune
+	comment := false
+	start := 0
+
+	runes := []rune(script)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case comment:
+			if r == '\n' {
+				comment = false
+			}
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			comment = true
+		case r == ';':
+			statements = append(statements, string(runes[start:i]))
+			start = i + 1
+		}
+	}
+
+	if rest := string(runes[start:]); strings.TrimSpace(rest) != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
@@ -0,0 +1,47 @@
+//go:build integration
+
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/bhmt/tittlemanscrest/migrations"
+	"github.com/bhmt/tittlemanscrest/repository"
+	_ "github.com/lib/pq"
+)
+
+func TestIntegrationMigrator(t *testing.T) {
+	postgres_db, ok := os.LookupEnv("POSTGRES_DB")
+	if !ok {
+		postgres_db = "localhost"
+	}
+
+	dns := fmt.Sprintf("postgresql://postgres:postgres@%s:5432/gotest?sslmode=disable", postgres_db)
+	session, err := repository.NewSession("postgres", dns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := repository.NewMigrator(session, repository.Postgres, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if !s.Applied || s.Dirty {
+			t.Errorf("version %d should be applied and clean got=%+v", s.Version, s)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/migrations"
+	"github.com/bhmt/tittlemanscrest/repository"
+	_ "modernc.org/sqlite"
+)
+
+func migrationFiles() fstest.MapFS {
+	return fstest.MapFS{
+		"000001_gadget.up.sql":   {Data: []byte("create table gadget (id integer primary key, name text);\ninsert into gadget (name) values ('semi;colon');")},
+		"000001_gadget.down.sql": {Data: []byte("drop table gadget;")},
+		"000002_gizmo.up.sql":    {Data: []byte("-- gizmos; belong to gadgets\ncreate table gizmo (id integer primary key, gadget_id integer);")},
+		"000002_gizmo.down.sql":  {Data: []byte("drop table gizmo;")},
+		"000003_broken.down.sql": {Data: []byte("select 1;")},
+		"000003_broken.up.sql":   {Data: []byte("create table widget (id integer primary key);\ninsert into missing values (1);")},
+		"README.md":              {Data: []byte("ignored")},
+	}
+}
+
+func migrationSession(t *testing.T) *repository.Session {
+	session, err := repository.NewSession("sqlite", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.SetMaxOpenConns(1)
+	t.Cleanup(func() { session.Close() })
+	return session
+}
+
+func applied(t *testing.T, m *repository.Migrator) []int64 {
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions := []int64{}
+	for _, s := range statuses {
+		if s.Applied {
+			versions = append(versions, s.Version)
+		}
+	}
+	return versions
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	session := migrationSession(t)
+
+	files := migrationFiles()
+	maps.DeleteFunc(files, func(name string, _ *fstest.MapFile) bool { return strings.HasPrefix(name, "000003") })
+
+	m, err := repository.NewMigrator(session, repository.SQLite, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := applied(t, m); len(got) != 2 {
+		t.Errorf("up should apply 2 versions got=%v", got)
+	}
+
+	var name string
+	if err := session.QueryRowContext(ctx, `select name from gadget`).Scan(&name); err != nil || name != "semi;colon" {
+		t.Errorf("seed want=semi;colon got=%s err=%v", name, err)
+	}
+
+	if err := m.Goto(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := applied(t, m); len(got) != 1 || got[0] != 1 {
+		t.Errorf("goto 1 want=[1] got=%v", got)
+	}
+
+	if err := m.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := applied(t, m); len(got) != 0 {
+		t.Errorf("down should revert all got=%v", got)
+	}
+	if _, err := session.ExecContext(ctx, `select 1 from gadget`); err == nil {
+		t.Error("down should drop gadget")
+	}
+
+	if err := m.Down(ctx, -1); !errors.Is(err, repository.ErrMigrationSteps) {
+		t.Errorf("negative steps want=ErrMigrationSteps got=%v", err)
+	}
+
+	if err := m.Goto(ctx, 7); !errors.Is(err, repository.ErrMigrationNotFound) {
+		t.Errorf("goto unknown want=ErrMigrationNotFound got=%v", err)
+	}
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	files["000001_gadget.up.sql"] = &fstest.MapFile{Data: []byte("create table gadget (id integer primary key);")}
+	changed, err := repository.NewMigrator(session, repository.SQLite, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := changed.Up(ctx); !errors.Is(err, repository.ErrMigrationChecksum) {
+		t.Errorf("edited migration want=ErrMigrationChecksum got=%v", err)
+	}
+}
+
+func TestMigratorDirty(t *testing.T) {
+	ctx := context.Background()
+	session := migrationSession(t)
+
+	transactional, err := repository.NewMigrator(session, repository.SQLite, migrationFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transactional.Up(ctx); err == nil {
+		t.Fatal("broken migration should fail")
+	}
+	if got := applied(t, transactional); len(got) != 2 {
+		t.Errorf("failed transactional migration should not be recorded got=%v", got)
+	}
+	if _, err := session.ExecContext(ctx, `select 1 from widget`); err == nil {
+		t.Error("failed transactional migration should be rolled back")
+	}
+
+	dialect := repository.SQLite
+	dialect.Transactional = false
+	dialect.Split = true
+	m, err := repository.NewMigrator(session, dialect, migrationFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Up(ctx); err == nil {
+		t.Fatal("broken migration should fail")
+	}
+	if err := m.Up(ctx); !errors.Is(err, repository.ErrMigrationDirty) {
+		t.Errorf("dirty database want=ErrMigrationDirty got=%v", err)
+	}
+
+	if _, err := session.ExecContext(ctx, `drop table widget`); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Force(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := applied(t, m); len(got) != 1 || got[0] != 1 {
+		t.Errorf("force 2 then down want=[1] got=%v", got)
+	}
+}
+
+func TestMigratorNoDown(t *testing.T) {
+	ctx := context.Background()
+	session := migrationSession(t)
+
+	files := migrationFiles()
+	maps.DeleteFunc(files, func(name string, _ *fstest.MapFile) bool {
+		return strings.HasPrefix(name, "000003") || name == "000001_gadget.down.sql"
+	})
+	m, err := repository.NewMigrator(session, repository.SQLite, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Goto(ctx, 0); !errors.Is(err, repository.ErrMigrationInvalid) {
+		t.Errorf("revert without down file want=ErrMigrationInvalid got=%v", err)
+	}
+	if got := applied(t, m); len(got) != 2 {
+		t.Errorf("failed revert should leave both versions applied got=%v", got)
+	}
+	if _, err := session.ExecContext(ctx, `select 1 from gizmo`); err != nil {
+		t.Errorf("failed revert should not run any down file: %v", err)
+	}
+
+	if err := m.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := applied(t, m); len(got) != 1 || got[0] != 1 {
+		t.Errorf("down with a down file want=[1] got=%v", got)
+	}
+}
+
+func TestMigratorLock(t *testing.T) {
+	session := migrationSession(t)
+	query := `create table schema_lock (id integer primary key, holder text not null, locked_at integer not null);
+insert into schema_lock (id, holder, locked_at) values (1, 'other', $1);`
+	if _, err := session.Exec(query, time.Now().UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+
+	files := migrationFiles()
+	maps.DeleteFunc(files, func(name string, _ *fstest.MapFile) bool { return strings.HasPrefix(name, "000003") })
+
+	m, err := repository.NewMigrator(session, repository.SQLite, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	if err := m.Up(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("held lock want=DeadlineExceeded got=%v", err)
+	}
+
+	dialect := repository.SQLite
+	dialect.Lock = repository.SQLiteLock(time.Millisecond)
+	stale, err := repository.NewMigrator(session, dialect, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stale.Up(context.Background()); err != nil {
+		t.Fatalf("stale lock should be taken over got=%v", err)
+	}
+
+	var n int
+	if err := session.QueryRow(`select count(*) from schema_lock`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("unlock should delete the row got=%d err=%v", n, err)
+	}
+}
+
+func TestSQLiteLockRefresh(t *testing.T) {
+	session, err := repository.NewSession("sqlite", "file:"+filepath.Join(t.TempDir(), "lock.db")+"?_pragma=busy_timeout%3d1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	ctx := context.Background()
+	lock := repository.SQLiteLock(60 * time.Millisecond)
+
+	holding, err := session.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holding.Close()
+	unlock, err := lock(ctx, holding)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The run outlives the stale timeout, its refreshed lock must not be taken over.
+	time.Sleep(200 * time.Millisecond)
+
+	waiting, err := session.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer waiting.Close()
+
+	waitCtx, cancel := context.WithTimeout(ctx, 150*time.Millisecond)
+	defer cancel()
+	if _, err := lock(waitCtx, waiting); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("refreshed lock want=DeadlineExceeded got=%v", err)
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lock(ctx, waiting); err != nil {
+		t.Errorf("released lock should be acquired got=%v", err)
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	list, err := repository.ReadMigrations(migrations.FS, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) == 0 || list[0].Version != 1 || list[0].Name != "init" || list[0].Down == "" {
+		t.Errorf("embedded migrations should start with 000001_init got=%+v", list)
+	}
+}
@@ -0,0 +1,228 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSchedule = fmt.Errorf("invalid schedule")
+
+// Schedule returns the next activation strictly after t, the zero time when there is none.
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// Every activates at a fixed interval from the previous activation, Scheduler.Add rejects d <= 0.
+func Every(d time.Duration) Schedule {
+	return every(d)
+}
+
+// Cron is a parsed cron expression, see ParseCron.
+type Cron struct {
+	second, minute, hour, dom, month, dow uint64
+	anyDom, anyDow                        bool
+	loc                                   *time.Location
+}
+
+type bounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	seconds = bounds{0, 59, nil}
+	minutes = bounds{0, 59, nil}
+	hours   = bounds{0, 23, nil}
+	doms    = bounds{1, 31, nil}
+	months  = bounds{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dows = bounds{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// ParseCron parses an expression of six fields, second minute hour day-of-month month day-of-week,
+// or of five fields without seconds, e.g. "*/30 * * * * *" or "0 9 * * mon-fri".
+// Fields take lists, ranges, steps and month or weekday names.
+// When both days are restricted either matches, like cron.
+// Descriptors @yearly, @monthly, @weekly, @daily and @hourly and "@every 90s" are accepted.
+// A CRON_TZ=Europe/Berlin or TZ=Europe/Berlin prefix sets the time zone, time.Local otherwise.
+func ParseCron(expr string) (Schedule, error) {
+	loc := time.Local
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "CRON_TZ=") || strings.HasPrefix(expr, "TZ=") {
+		zone, rest, _ := strings.Cut(expr, " ")
+		_, name, _ := strings.Cut(zone, "=")
+
+		var err error
+		if loc, err = time.LoadLocation(name); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+		}
+		expr = strings.TrimSpace(rest)
+	}
+
+	if d, ok := strings.CutPrefix(expr, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSchedule, expr)
+		}
+		return Every(interval), nil
+	}
+	if descriptor, ok := descriptors[expr]; ok {
+		expr = descriptor
+	}
+
+	parts := strings.Fields(expr)
+	switch len(parts) {
+	case 5:
+		parts = append([]string{"0"}, parts...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("%w: %q want 5 or 6 fields got %d", ErrInvalidSchedule, expr, len(parts))
+	}
+
+	c := Cron{loc: loc}
+	var err error
+	fields := []struct {
+		bits   *uint64
+		bounds bounds
+	}{
+		{&c.second, seconds}, {&c.minute, minutes}, {&c.hour, hours},
+		{&c.dom, doms}, {&c.month, months}, {&c.dow, dows},
+	}
+	for i, f := range fields {
+		if *f.bits, err = parseField(parts[i], f.bounds); err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidSchedule, expr, err)
+		}
+	}
+
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.anyDom = parts[3] == "*" || parts[3] == "?"
+	c.anyDow = parts[5] == "*" || parts[5] == "?"
+	return &c, nil
+}
+
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		expr, stepText, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+		}
+
+		var lo, hi int
+		switch {
+		case expr == "*" || expr == "?":
+			lo, hi = b.min, b.max
+		case strings.Contains(expr, "-"):
+			from, to, _ := strings.Cut(expr, "-")
+			var err error
+			if lo, err = b.value(from); err != nil {
+				return 0, err
+			}
+			if hi, err = b.value(to); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if lo, err = b.value(expr); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if hasStep {
+				hi = b.max
+			}
+		}
+
+		if lo > hi {
+			return 0, fmt.Errorf("invalid range %q", part)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (b bounds) value(text string) (int, error) {
+	if v, ok := b.names[strings.ToLower(text)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(text)
+	if err != nil || v < b.min || v > b.max {
+		return 0, fmt.Errorf("value %q out of range %d-%d", text, b.min, b.max)
+	}
+	return v, nil
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<t.Weekday()) != 0
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the next matching second after t in the schedule time zone,
+// the zero time when nothing matches within five years, e.g. on 30 February.
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.In(c.loc).Truncate(time.Second).Add(time.Second)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		h, mi, s := t.Clock()
+
+		var next time.Time
+		switch {
+		case c.month&(1<<m) == 0:
+			next = time.Date(y, m+1, 1, 0, 0, 0, 0, c.loc)
+		case !c.dayMatches(t):
+			next = time.Date(y, m, d+1, 0, 0, 0, 0, c.loc)
+		case c.hour&(1<<h) == 0:
+			next = time.Date(y, m, d, h+1, 0, 0, 0, c.loc)
+		case c.minute&(1<<mi) == 0:
+			next = time.Date(y, m, d, h, mi+1, 0, 0, c.loc)
+		case c.second&(1<<s) == 0:
+			next = t.Add(time.Second)
+		default:
+			return t
+		}
+
+		// time.Date resolves a repeated hour to its first occurrence when clocks go back.
+		if !next.After(t) {
+			next = t.Add(time.Second)
+		}
+		t = next
+	}
+	return time.Time{}
+}
@@ -0,0 +1,54 @@
+package scheduler_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/scheduler"
+)
+
+func TestParseCron(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	from := time.Date(2026, time.March, 28, 23, 59, 58, 500, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"TZ=UTC */15 * * * * *", time.Date(2026, time.March, 29, 0, 0, 0, 0, time.UTC)},
+		{"TZ=UTC 0 30 9 * * mon-fri", time.Date(2026, time.March, 30, 9, 30, 0, 0, time.UTC)},
+		{"TZ=UTC 0 0 1 15 * *", time.Date(2026, time.April, 15, 1, 0, 0, 0, time.UTC)},
+		{"TZ=UTC 0 0 * * sun", time.Date(2026, time.March, 29, 0, 0, 0, 0, time.UTC)},
+		{"TZ=UTC 0 0 1 13 * 5", time.Date(2026, time.April, 3, 1, 0, 0, 0, time.UTC)},
+		{"TZ=UTC @monthly", time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=Europe/Berlin 0 30 2 * * *", time.Date(2026, time.March, 30, 2, 30, 0, 0, berlin)},
+		{"TZ=Europe/Berlin 0 0 9 * * *", time.Date(2026, time.March, 29, 9, 0, 0, 0, berlin)},
+		{"@every 90s", from.Add(90 * time.Second)},
+	}
+
+	for _, test := range tests {
+		schedule, err := scheduler.ParseCron(test.expr)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		if got := schedule.Next(from); !got.Equal(test.want) {
+			t.Errorf("%q next want=%s got=%s", test.expr, test.want, got)
+		}
+	}
+
+	never, _ := scheduler.ParseCron("TZ=UTC 0 0 0 30 feb *")
+	if got := never.Next(from); !got.IsZero() {
+		t.Errorf("30 February should never match got=%s", got)
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * * *", "*/0 * * * *", "5-1 * * * *", "TZ=Mars/Olympus * * * * *", "@every soon"} {
+		if _, err := scheduler.ParseCron(expr); !errors.Is(err, scheduler.ErrInvalidSchedule) {
+			t.Errorf("%q want=ErrInvalidSchedule got=%v", expr, err)
+		}
+	}
+}
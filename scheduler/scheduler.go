@@ -0,0 +1,253 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/logging"
+	"github.com/bhmt/tittlemanscrest/repository"
+)
+
+var ErrInvalidJob = fmt.Errorf("invalid job")
+
+// Overlap decides what happens when a job is due while its previous run is still going.
+type Overlap int
+
+const (
+	// Skip drops the activation.
+	Skip Overlap = iota
+	// Queue runs once more after the current run, further activations are merged into that one.
+	Queue
+)
+
+// Job is a function run on a schedule.
+// Jitter delays each activation by a random duration up to Jitter, spreading replicas and jobs.
+// Timeout cancels the context of a run, zero leaves it running until the scheduler stops.
+// CatchUp runs the job once on start when an activation was missed since the last run
+// recorded in the store, e.g. while the service was down.
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Run      func(ctx context.Context) error
+	Jitter   time.Duration
+	Timeout  time.Duration
+	Overlap  Overlap
+	CatchUp  bool
+}
+
+// LastRunStore keeps the time of the last successful activation of each job.
+type LastRunStore interface {
+	LastRun(ctx context.Context, job string) (time.Time, error)
+	SetLastRun(ctx context.Context, job string, at time.Time) error
+}
+
+// SQL stores last runs in the job_run table as unix milliseconds.
+type SQL struct {
+	session *repository.Session
+}
+
+func NewSQL(session *repository.Session) *SQL {
+	return &SQL{session: session}
+}
+
+// LastRun returns the zero time for a job that never ran.
+func (s *SQL) LastRun(ctx context.Context, job string) (time.Time, error) {
+	var ms int64
+	err := s.session.QueryRowContext(ctx, `select last_run from job_run where name = $1`, job).Scan(&ms)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(ms), nil
+}
+
+func (s *SQL) SetLastRun(ctx context.Context, job string, at time.Time) error {
+	query := `insert into job_run (name, last_run) values ($1, $2)
+on conflict (name) do update set last_run = excluded.last_run`
+	_, err := s.session.ExecContext(ctx, query, job, at.UnixMilli())
+	return err
+}
+
+// Scheduler runs jobs on their schedules and logs every run.
+type Scheduler struct {
+	logger *slog.Logger
+	store  LastRunStore
+	jobs   []Job
+}
+
+// WithStore persists last runs, it is required by jobs with CatchUp.
+func WithStore(store LastRunStore) func(*Scheduler) {
+	return func(s *Scheduler) {
+		s.store = store
+	}
+}
+
+func New(logger *slog.Logger, opts ...func(*Scheduler)) *Scheduler {
+	s := Scheduler{logger: logger}
+	for _, o := range opts {
+		o(&s)
+	}
+	return &s
+}
+
+// Add registers a job, jobs added after Run starts are not scheduled.
+func (s *Scheduler) Add(job Job) error {
+	switch {
+	case job.Name == "":
+		return fmt.Errorf("%w: missing name", ErrInvalidJob)
+	case job.Schedule == nil || job.Run == nil:
+		return fmt.Errorf("%w: %s needs a schedule and a run function", ErrInvalidJob, job.Name)
+	case job.CatchUp && s.store == nil:
+		return fmt.Errorf("%w: %s catch up needs a store", ErrInvalidJob, job.Name)
+	}
+	if d, ok := job.Schedule.(every); ok && d <= 0 {
+		return fmt.Errorf("%w: %s interval must be positive", ErrInvalidJob, job.Name)
+	}
+
+	s.jobs = append(s.jobs, job)
+	return nil
+}
+
+// Run schedules the jobs until ctx is done and waits for running jobs to return.
+// Jobs log through logging.FromContext with the scheduler logger and a job attribute.
+// It fits cmd.Run, or cmd.Leader to run the jobs on a single replica.
+func (s *Scheduler) Run(ctx context.Context) {
+	ctx = logging.WithLogger(ctx, s.logger)
+
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.schedule(ctx, job)
+		}()
+	}
+	wg.Wait()
+}
+
+// schedule triggers the runs of a job. A worker takes the activations from a buffer of one,
+// Skip drops activations while the worker is running and Queue while the buffer is full.
+func (s *Scheduler) schedule(ctx context.Context, job Job) {
+	ctx = logging.With(ctx, slog.String("job", job.Name))
+	logger := logging.FromContext(ctx)
+
+	activations := make(chan time.Time, 1)
+	var running atomic.Bool
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case at := <-activations:
+				running.Store(true)
+				s.run(ctx, job, at)
+				running.Store(false)
+			}
+		}
+	}()
+	defer func() { <-done }()
+
+	trigger := func(at time.Time) {
+		if job.Overlap == Skip && running.Load() {
+			logger.WarnContext(ctx, "job overlap", slog.Time("scheduled", at), slog.String("overlap", job.Overlap.String()))
+			return
+		}
+
+		select {
+		case activations <- at:
+		default:
+			logger.WarnContext(ctx, "job overlap", slog.Time("scheduled", at), slog.String("overlap", job.Overlap.String()))
+		}
+	}
+
+	now := time.Now()
+	if job.CatchUp {
+		last, err := s.store.LastRun(ctx, job.Name)
+		if err != nil {
+			logger.ErrorContext(ctx, "job last run", slog.String("error", err.Error()))
+		}
+		if missed := job.Schedule.Next(last); !last.IsZero() && !missed.IsZero() && missed.Before(now) {
+			logger.InfoContext(ctx, "job catch up", slog.Time("missed", missed))
+			trigger(missed)
+		}
+	}
+
+	for next := job.Schedule.Next(now); !next.IsZero(); {
+		delay := time.Until(next)
+		if job.Jitter > 0 {
+			delay += rand.N(job.Jitter)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		trigger(next)
+
+		// The jitter is not carried over to the next activation, while a slow host
+		// or a suspended process skips the activations already past.
+		after := next
+		if now := time.Now(); now.Sub(next) > job.Jitter {
+			after = now
+		}
+		next = job.Schedule.Next(after)
+	}
+}
+
+// run calls the job with its timeout and records a successful run, a panic is logged as an error.
+// Failed runs are not recorded so CatchUp retries them after a restart.
+func (s *Scheduler) run(ctx context.Context, job Job, scheduled time.Time) {
+	if job.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, job.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+			}
+		}()
+		return job.Run(ctx)
+	}()
+
+	logger := logging.FromContext(ctx)
+	attrs := []any{slog.Time("scheduled", scheduled), slog.Duration("duration", time.Since(start))}
+	if err != nil {
+		logger.ErrorContext(ctx, "job failed", append(attrs, slog.String("error", err.Error()))...)
+		return
+	}
+	logger.InfoContext(ctx, "job done", attrs...)
+
+	if s.store != nil {
+		if err := s.store.SetLastRun(context.WithoutCancel(ctx), job.Name, scheduled); err != nil {
+			logger.ErrorContext(ctx, "job last run", slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (o Overlap) String() string {
+	if o == Queue {
+		return "queue"
+	}
+	return "skip"
+}
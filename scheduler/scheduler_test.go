@@ -0,0 +1,184 @@
+package scheduler_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bhmt/tittlemanscrest/repository"
+	"github.com/bhmt/tittlemanscrest/scheduler"
+	_ "modernc.org/sqlite"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestScheduler(t *testing.T) {
+	var logs syncBuffer
+	s := scheduler.New(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	var ticks, overlapping, panics atomic.Int32
+	timedOut := make(chan error, 1)
+	jobs := []scheduler.Job{
+		{Name: "tick", Schedule: scheduler.Every(20 * time.Millisecond), Jitter: 5 * time.Millisecond, Run: func(ctx context.Context) error {
+			ticks.Add(1)
+			return nil
+		}},
+		{Name: "slow", Schedule: scheduler.Every(10 * time.Millisecond), Run: func(ctx context.Context) error {
+			overlapping.Add(1)
+			time.Sleep(60 * time.Millisecond)
+			return nil
+		}},
+		{Name: "panics", Schedule: scheduler.Every(50 * time.Millisecond), Run: func(ctx context.Context) error {
+			panics.Add(1)
+			panic("antigravity")
+		}},
+		{Name: "timeout", Schedule: scheduler.Every(50 * time.Millisecond), Timeout: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			select {
+			case timedOut <- ctx.Err():
+			default:
+			}
+			return ctx.Err()
+		}},
+	}
+	for _, job := range jobs {
+		if err := s.Add(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Add(scheduler.Job{Name: "catch", Schedule: scheduler.Every(time.Hour), Run: jobs[0].Run, CatchUp: true}); !errors.Is(err, scheduler.ErrInvalidJob) {
+		t.Errorf("catch up without store want=ErrInvalidJob got=%v", err)
+	}
+	if err := s.Add(scheduler.Job{Name: "zero", Schedule: scheduler.Every(0), Run: jobs[0].Run}); !errors.Is(err, scheduler.ErrInvalidJob) {
+		t.Errorf("zero interval want=ErrInvalidJob got=%v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	s.Run(ctx)
+
+	if n := ticks.Load(); n < 5 {
+		t.Errorf("tick should run about 12 times got=%d", n)
+	}
+	if n := overlapping.Load(); n > 5 {
+		t.Errorf("slow runs should be skipped while running got=%d runs", n)
+	}
+	if panics.Load() == 0 || !strings.Contains(logs.String(), "panic: antigravity") {
+		t.Errorf("panic should be recovered and logged got=%s", logs.String())
+	}
+	if err := <-timedOut; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("timeout want=DeadlineExceeded got=%v", err)
+	}
+	if !strings.Contains(logs.String(), `msg="job overlap" job=slow`) || !strings.Contains(logs.String(), `msg="job done" job=tick`) {
+		t.Errorf("runs and overlaps should be logged got=%s", logs.String())
+	}
+}
+
+func TestSchedulerQueue(t *testing.T) {
+	s := scheduler.New(slog.New(slog.DiscardHandler))
+
+	var runs atomic.Int32
+	s.Add(scheduler.Job{Name: "queued", Schedule: scheduler.Every(10 * time.Millisecond), Overlap: scheduler.Queue, Run: func(ctx context.Context) error {
+		runs.Add(1)
+		time.Sleep(45 * time.Millisecond)
+		return nil
+	}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	s.Run(ctx)
+
+	if n := runs.Load(); n < 2 || n > 3 {
+		t.Errorf("queued job should run back to back, want 2-3 runs got=%d", n)
+	}
+}
+
+func TestSchedulerCatchUp(t *testing.T) {
+	session, err := repository.NewSession("sqlite", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	if _, err := session.Exec(`create table job_run (name text primary key, last_run bigint not null)`); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	store := scheduler.NewSQL(session)
+	if last, err := store.LastRun(ctx, "report"); err != nil || !last.IsZero() {
+		t.Fatalf("unknown job want zero last run got=%s err=%v", last, err)
+	}
+
+	last := time.Now().Add(-3 * time.Hour).Truncate(time.Millisecond)
+	if err := store.SetLastRun(ctx, "report", last); err != nil {
+		t.Fatal(err)
+	}
+
+	ran := make(chan struct{}, 1)
+	s := scheduler.New(slog.New(slog.DiscardHandler), scheduler.WithStore(store))
+	s.Add(scheduler.Job{Name: "report", Schedule: scheduler.Every(time.Hour), CatchUp: true, Run: func(ctx context.Context) error {
+		ran <- struct{}{}
+		return nil
+	}})
+
+	runCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-ran:
+		case <-time.After(time.Second):
+			t.Error("missed run should be caught up on start")
+		}
+		cancel()
+	}()
+	s.Run(runCtx)
+
+	got, err := store.LastRun(ctx, "report")
+	if err != nil || !got.Equal(last.Add(time.Hour)) {
+		t.Errorf("last run should be the missed activation want=%s got=%s err=%v", last.Add(time.Hour), got, err)
+	}
+
+	if err := store.SetLastRun(ctx, "flaky", last); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := make(chan struct{}, 1)
+	s = scheduler.New(slog.New(slog.DiscardHandler), scheduler.WithStore(store))
+	s.Add(scheduler.Job{Name: "flaky", Schedule: scheduler.Every(time.Hour), CatchUp: true, Run: func(ctx context.Context) error {
+		failed <- struct{}{}
+		return errors.New("antigravity")
+	}})
+
+	runCtx, cancel = context.WithCancel(ctx)
+	go func() {
+		<-failed
+		cancel()
+	}()
+	s.Run(runCtx)
+
+	if got, err := store.LastRun(ctx, "flaky"); err != nil || !got.Equal(last) {
+		t.Errorf("failed run should not be recorded want=%s got=%s err=%v", last, got, err)
+	}
+}